@@ -50,7 +50,7 @@ func pushNotificationAndroid(req gaurun.RequestGaurunNotification) bool {
 	msg.CollapseKey = req.CollapseKey
 	msg.DelayWhileIdle = req.DelayWhileIdle
 	msg.TimeToLive = req.TimeToLive
-	msg.Priority = req.Priority
+	msg.Priority = gcm.Priority(req.Priority)
 
 	_, err := GCMClient.Send(msg, AccessKeyJsonData)
 	if err != nil {
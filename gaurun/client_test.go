@@ -3,6 +3,7 @@ package gaurun
 import (
 	"testing"
 
+	"github.com/mercari/gaurun/gcm"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,3 +15,7 @@ func TestKeepAliveInterval(t *testing.T) {
 	assert.Equal(t, 90, keepAliveInterval(300))
 	assert.Equal(t, 90, keepAliveInterval(600))
 }
+
+func TestGCMVersion(t *testing.T) {
+	assert.Equal(t, Version, gcm.Version)
+}
@@ -1,6 +1,7 @@
 package gaurun
 
 const (
+	// Version is the version of gaurun. Bump gcm.Version along with it.
 	Version = "0.13.1"
 )
 
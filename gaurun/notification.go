@@ -151,7 +151,7 @@ func pushNotificationAndroid(req RequestGaurunNotification) error {
 	msg.CollapseKey = req.CollapseKey
 	msg.DelayWhileIdle = req.DelayWhileIdle
 	msg.TimeToLive = req.TimeToLive
-	msg.Priority = req.Priority
+	msg.Priority = gcm.Priority(req.Priority)
 
 	stime := time.Now()
 	_, err := GCMClient.Send(msg, AccessKeyJsonData)
@@ -0,0 +1,264 @@
+package gcm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SendBatch sends a message to the FCM server through the batch endpoint.
+// RegistrationIDs are split into chunks of at most 500 tokens and each chunk
+// is delivered as a single multipart/mixed HTTP request, so large audiences
+// need far fewer round-trips than Send. The returned Response holds one Result
+// per registration ID in the order of msg.RegistrationIDs. When a chunk fails,
+// the chunks after it are not sent and its error is returned along with the
+// Response, whose Results of the tokens of these chunks carry the error. A
+// message to a Topic or Condition is a single request and is sent like with
+// Send.
+func (c *Client) SendBatch(msg *Message, acsJsonData []byte) (*Response, error) {
+	msg = c.normalizeTokens(msg)
+	if err := c.validate(msg); err != nil {
+		return nil, err
+	}
+	if msg.Topic != "" || msg.Condition != "" {
+		return c.send(context.Background(), msg, acsJsonData, &sendOptions{})
+	}
+
+	return c.sendBatch(msg, acsJsonData)
+}
+
+func (c *Client) sendBatch(msg *Message, acsJsonData []byte) (*Response, error) {
+	if err := c.verifyImages(context.Background(), msg); err != nil {
+		return nil, err
+	}
+
+	msg = c.withDefaultData(msg)
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URL %q: %s", c.URL, err)
+	}
+
+	start := c.now()
+	acsToken, err := c.accessToken(context.Background(), acsJsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &Response{Validated: msg.DryRun, TokenLatency: c.now().Sub(start)}
+	for start := 0; start < len(msg.RegistrationIDs); start += maxBatchTokens {
+		end := start + maxBatchTokens
+		if end > len(msg.RegistrationIDs) {
+			end = len(msg.RegistrationIDs)
+		}
+
+		tokens := msg.RegistrationIDs[start:end]
+		err := c.breaker.allow()
+		var results []Result
+		if err == nil {
+			results, err = c.sendBatchChunk(u.Path, acsToken, msg, tokens)
+			c.breaker.record(context.Background(), err)
+		}
+		if err != nil {
+			// The chunks before were delivered; keep their results and fail
+			// the tokens of this chunk and of the ones not sent.
+			for _, token := range msg.RegistrationIDs[start:] {
+				result := newErrorResult(token, err)
+				result.CorrelationID = msg.CorrelationID
+				result.Meta = msg.Meta
+				response.Results = append(response.Results, result)
+			}
+			response.classify(c.PruneUnregistered, false)
+			return response, err
+		}
+		for i := range results {
+			results[i].Token = tokens[i]
+			results[i].CorrelationID = msg.CorrelationID
+			results[i].Meta = msg.Meta
+			if msg.DryRun {
+				results[i].MessageID = ""
+			}
+		}
+		response.Results = append(response.Results, results...)
+	}
+	response.classify(c.PruneUnregistered, false)
+
+	return response, nil
+}
+
+func (c *Client) sendBatchChunk(path, acsToken string, msg *Message, tokens []string) ([]Result, error) {
+	if len(tokens) > maxBatchTokens {
+		return nil, fmt.Errorf("a batch request may contain at most %d registration tokens, got %d", maxBatchTokens, len(tokens))
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	for i, token := range tokens {
+		body, err := json.Marshal(WrappedMessage{ValidateOnly: msg.DryRun, Message: newMessageV1(msg, token, c.now())})
+		if err != nil {
+			return nil, err
+		}
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-Transfer-Encoding", "binary")
+		header.Set("Content-ID", fmt.Sprintf("<%d>", i+1))
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(part, "POST %s HTTP/1.1\r\n", path)
+		fmt.Fprintf(part, "Content-Type: application/json\r\n")
+		fmt.Fprintf(part, "Authorization: Bearer %s\r\n", acsToken)
+		fmt.Fprintf(part, "Content-Length: %d\r\n", len(body))
+		fmt.Fprintf(part, "\r\n")
+		part.Write(body)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := c.waitRateLimit(context.Background(), len(tokens)); err != nil {
+		return nil, err
+	}
+	if err := c.acquireInFlight(context.Background()); err != nil {
+		return nil, err
+	}
+	defer c.releaseInFlight()
+
+	req, err := http.NewRequest("POST", c.BatchURL, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", acsToken))
+	req.Header.Add("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%s", mw.Boundary()))
+	req.Header.Set("User-Agent", c.userAgent())
+	if err := c.sign(req, buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	start := c.now()
+	resp, err := c.Http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	resp.Body = c.limitBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, newFCMError(resp.StatusCode, resp.Status, errBody)
+	}
+
+	results, err := c.parseBatchResponse(resp, len(tokens))
+	if err != nil {
+		return nil, err
+	}
+	latency := c.now().Sub(start)
+	for i := range results {
+		results[i].Latency = latency
+		results[i].Attempts = 1
+	}
+	return results, nil
+}
+
+// parseBatchResponse reads the multipart/mixed batch response and returns
+// one Result per sub-request, ordered like the sub-requests were sent. The
+// Result of a sub-request without a response part carries an error.
+func (c *Client) parseBatchResponse(resp *http.Response, n int) ([]Result, error) {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse batch response content type: %s", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("unexpected batch response content type %q", mediaType)
+	}
+
+	results := make([]Result, n)
+	received := make([]bool, n)
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to read batch response: %w", err)
+		}
+
+		index := batchPartIndex(part.Header.Get("Content-ID"), i)
+		if index < 0 || index >= n {
+			return nil, fmt.Errorf("unexpected batch response part %q", part.Header.Get("Content-ID"))
+		}
+
+		subResp, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read batch response part: %w", err)
+		}
+		results[index] = c.batchPartResult(subResp)
+		received[index] = true
+		subResp.Body.Close()
+	}
+
+	for i := range results {
+		if !received[i] {
+			results[i] = Result{Error: "the batch response has no part for the sub-request"}
+		}
+	}
+	return results, nil
+}
+
+// batchPartIndex returns the zero-based sub-request index for the Content-ID
+// of a response part ("<response-N>"), falling back to the part position.
+func batchPartIndex(contentID string, position int) int {
+	id := strings.TrimSuffix(strings.TrimPrefix(contentID, "<"), ">")
+	id = strings.TrimPrefix(id, "response-")
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return position
+	}
+	return n - 1
+}
+
+func (c *Client) batchPartResult(resp *http.Response) Result {
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := ioutil.ReadAll(resp.Body)
+		if fcmErr := newFCMError(resp.StatusCode, resp.Status, errBody); fcmErr.code() != "" {
+			fcmErr.QuotaHeaders = quotaHeaders(resp.Header)
+			return newErrorResult("", fcmErr)
+		}
+		return Result{Error: resp.Status, QuotaHeaders: quotaHeaders(resp.Header)}
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Result{Error: err.Error()}
+	}
+	if !c.IgnoreOKErrorBody {
+		if fcmErr := newOKBodyError(resp, respBody); fcmErr != nil {
+			fcmErr.QuotaHeaders = quotaHeaders(resp.Header)
+			return newErrorResult("", fcmErr)
+		}
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(respBody, &body); err != nil {
+		return Result{Error: err.Error()}
+	}
+
+	return Result{MessageID: body.Name, QuotaHeaders: quotaHeaders(resp.Header)}
+}
@@ -0,0 +1,248 @@
+package gcm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// startTestBatchServer returns a server answering batch requests. Sub-requests
+// addressed to the token "invalid" fail with UNREGISTERED, those addressed to
+// "wrapped" fail with UNREGISTERED in a 200 response, those addressed to
+// "missing" get no response part, all others succeed. A batch request with a
+// sub-request addressed to "fail" fails as a whole with 500.
+// The number of received batch requests is written to calls.
+func startTestBatchServer(t *testing.T, calls *int) *httptest.Server {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		*calls++
+
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			t.Errorf("invalid batch request content type: %s", err)
+			return
+		}
+
+		var tokens []string
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("invalid batch request: %s", err)
+				return
+			}
+
+			subReq, err := http.ReadRequest(bufio.NewReader(part))
+			if err != nil {
+				t.Errorf("invalid batch sub-request: %s", err)
+				return
+			}
+			var wrapped WrappedMessage
+			if err := json.NewDecoder(subReq.Body).Decode(&wrapped); err != nil {
+				t.Errorf("invalid batch sub-request body: %s", err)
+				return
+			}
+			tokens = append(tokens, wrapped.Message.Token)
+		}
+
+		for _, token := range tokens {
+			if token == "fail" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%s", mw.Boundary()))
+		for i, token := range tokens {
+			if token == "missing" {
+				continue
+			}
+			respPart, _ := mw.CreatePart(map[string][]string{
+				"Content-Type": {"application/http"},
+				"Content-ID":   {"<response-" + strconv.Itoa(i+1) + ">"},
+			})
+			if token == "invalid" {
+				fmt.Fprint(respPart, "HTTP/1.1 404 Not Found\r\nContent-Type: application/json\r\n\r\n")
+				fmt.Fprint(respPart, `{"error":{"code":404,"status":"UNREGISTERED"}}`)
+				continue
+			}
+			fmt.Fprint(respPart, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n")
+			if token == "wrapped" {
+				fmt.Fprint(respPart, `{"error":{"code":404,"status":"UNREGISTERED"}}`)
+				continue
+			}
+			fmt.Fprintf(respPart, `{"name":"projects/test/messages/%s"}`, token)
+		}
+		mw.Close()
+	}
+	return httptest.NewServer(http.HandlerFunc(handler))
+}
+
+func TestSendBatch(t *testing.T) {
+	var calls int
+	server := startTestBatchServer(t, &calls)
+	defer server.Close()
+
+	sender, err := NewClient(server.URL+"/v1/projects/test/messages:send", "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.BatchURL = server.URL + "/batch"
+
+	tokens := make([]string, 501)
+	for i := range tokens {
+		tokens[i] = strconv.Itoa(i)
+	}
+	tokens[3] = "invalid"
+
+	msg := NewMessage(map[string]interface{}{"key": "value"}, tokens...)
+	resp, err := sender.SendBatch(msg, testCredentials(t, server.URL+"/token"))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("expect 2 batch requests, got %d", calls)
+	}
+	if len(resp.Results) != len(tokens) {
+		t.Fatalf("expect %d results, got %d", len(tokens), len(resp.Results))
+	}
+	for i, result := range resp.Results {
+		if i == 3 {
+			if result.Error != "UNREGISTERED" {
+				t.Fatalf("#%d expect UNREGISTERED error, got %q", i, result.Error)
+			}
+			continue
+		}
+		if result.Token != tokens[i] {
+			t.Fatalf("#%d expect token %q, got %q", i, tokens[i], result.Token)
+		}
+		if want := "projects/test/messages/" + tokens[i]; result.MessageID != want {
+			t.Fatalf("#%d expect message ID %q, got %q", i, want, result.MessageID)
+		}
+	}
+	if resp.FailureCount != 1 || len(resp.InvalidTokens) != 0 {
+		t.Fatalf("expect the unregistered token to be a failure, got %d failures and %v", resp.FailureCount, resp.InvalidTokens)
+	}
+
+	sender.PruneUnregistered = true
+	resp, err = sender.SendBatch(msg, testCredentials(t, server.URL+"/token"))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if resp.FailureCount != 0 || len(resp.InvalidTokens) != 1 || resp.InvalidTokens[0] != "invalid" {
+		t.Fatalf("expect the unregistered token to be pruned, got %d failures and %v", resp.FailureCount, resp.InvalidTokens)
+	}
+}
+
+func TestSendBatchOKErrorBody(t *testing.T) {
+	var calls int
+	server := startTestBatchServer(t, &calls)
+	defer server.Close()
+
+	sender, err := NewClient(server.URL+"/v1/projects/test/messages:send", "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.BatchURL = server.URL + "/batch"
+
+	resp, err := sender.SendBatch(NewMessage(nil, "1", "wrapped"), testCredentials(t, server.URL+"/token"))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if resp.FailureCount != 1 || resp.Results[1].Error != "UNREGISTERED" || resp.Results[1].MessageID != "" {
+		t.Fatalf("expect the wrapped error to be a failure, got %+v", resp)
+	}
+}
+
+func TestSendBatchChunkFailure(t *testing.T) {
+	var calls int
+	server := startTestBatchServer(t, &calls)
+	defer server.Close()
+
+	sender, err := NewClient(server.URL+"/v1/projects/test/messages:send", "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.BatchURL = server.URL + "/batch"
+	sender.MaxRegistrationIDs = 1500
+
+	tokens := make([]string, 1001)
+	for i := range tokens {
+		tokens[i] = strconv.Itoa(i)
+	}
+	tokens[600] = "fail"
+
+	resp, err := sender.SendBatch(NewMessage(nil, tokens...), testCredentials(t, server.URL+"/token"))
+	var fcmErr *FCMError
+	if !errors.As(err, &fcmErr) || fcmErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expect the error of the failed chunk, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expect the chunks after the failed one not to be sent, got %d batch requests", calls)
+	}
+	if len(resp.Results) != len(tokens) || resp.FailureCount != 501 {
+		t.Fatalf("expect a result per token with the tokens of the failed and remaining chunks failed, got %d results and %d failures", len(resp.Results), resp.FailureCount)
+	}
+	for i, result := range resp.Results {
+		if result.Token != tokens[i] {
+			t.Fatalf("#%d expect the results in the order of the tokens, got %s", i, result.Token)
+		}
+		if delivered := result.Error == ""; delivered != (i < 500) {
+			t.Fatalf("#%d expect only the first chunk to be delivered, got %+v", i, result)
+		}
+	}
+}
+
+func TestSendBatchMissingPart(t *testing.T) {
+	var calls int
+	server := startTestBatchServer(t, &calls)
+	defer server.Close()
+
+	sender, err := NewClient(server.URL+"/v1/projects/test/messages:send", "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.BatchURL = server.URL + "/batch"
+	creds := testCredentials(t, server.URL+"/token")
+
+	resp, err := sender.SendBatch(NewMessage(nil, "1", "missing", "2"), creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if resp.FailureCount != 1 || resp.Results[1].Error == "" || resp.Results[0].Error != "" || resp.Results[2].Error != "" {
+		t.Fatalf("expect the token without a response part to fail, got %+v", resp)
+	}
+
+	sender.MaxResponseBodySize = 16
+	if _, err := sender.SendBatch(NewMessage(nil, "1"), creds); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("expect ErrResponseTooLarge, got %v", err)
+	}
+}
+
+func TestSendBatchChunkLimit(t *testing.T) {
+	sender, err := NewClient("http://localhost/v1/projects/test/messages:send", "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	tokens := make([]string, maxBatchTokens+1)
+	if _, err := sender.sendBatchChunk("/v1/projects/test/messages:send", "token", NewMessage(nil, tokens...), tokens); err == nil {
+		t.Fatalf("expect to be failed (more than %d tokens in a batch request)", maxBatchTokens)
+	}
+}
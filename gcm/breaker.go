@@ -0,0 +1,144 @@
+package gcm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker of a Client.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through. It is also the state of a client
+	// without a circuit breaker.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects requests with ErrCircuitOpen until the cooldown
+	// has passed.
+	CircuitOpen
+	// CircuitHalfOpen lets a single request through to probe whether FCM
+	// has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// WithCircuitBreaker stops the client from sending during an FCM outage:
+// after threshold consecutive requests fail with a transient error (a
+// network error, 429 or 5xx), the circuit opens and requests fail with
+// ErrCircuitOpen without being sent. Once cooldown has passed, the circuit
+// half-opens and lets one request through; it closes again when the request
+// succeeds and reopens for another cooldown when it fails. Retries count as
+// requests, so an open circuit also ends retrying.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) ClientOption {
+	return func(c *Client) {
+		c.breaker = &circuitBreaker{threshold: threshold, cooldown: cooldown}
+	}
+}
+
+// CircuitState returns the current state of the circuit breaker, e.g. for
+// metrics.
+func (c *Client) CircuitState() CircuitState {
+	return c.breaker.currentState()
+}
+
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	clock     func() time.Time
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow returns ErrCircuitOpen if a request must not be sent now.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.state = CircuitHalfOpen
+		b.probing = false
+	}
+
+	switch b.state {
+	case CircuitOpen:
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+
+	return nil
+}
+
+// record updates the breaker with the outcome of a request allowed by allow.
+// Requests aborted by ctx are not counted, though an aborted probe lets
+// another request probe the half-open circuit.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil && ctx.Err() != nil {
+		b.probing = false
+		return
+	}
+
+	if err == nil || !IsRetryable(err) {
+		b.state = CircuitClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.state = CircuitOpen
+		b.openedAt = b.now()
+		b.probing = false
+	}
+}
+
+func (b *circuitBreaker) currentState() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+func (b *circuitBreaker) now() time.Time {
+	if b.clock == nil {
+		return time.Now()
+	}
+	return b.clock()
+}
@@ -0,0 +1,90 @@
+package gcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var sends int
+	var abort context.CancelFunc
+	status := http.StatusServiceUnavailable
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		sends++
+		if abort != nil {
+			// Abort the send and wait for the client to give up, which the
+			// server notices once the body is read.
+			ioutil.ReadAll(r.Body)
+			abort()
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	clock := newFakeClock()
+	sender, err := NewClient(server.URL, "testAPIKey", WithCircuitBreaker(2, time.Minute), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	for i := 0; i < 2; i++ {
+		if _, err := sender.Send(NewMessage(nil, "1"), creds); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("#%d expect the FCM error, got %v", i, err)
+		}
+	}
+	if state := sender.CircuitState(); state != CircuitOpen {
+		t.Fatalf("expect the circuit to be open, got %s", state)
+	}
+
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expect ErrCircuitOpen, got %v", err)
+	}
+	if sends != 2 {
+		t.Fatalf("expect no request while the circuit is open, got %d sends", sends)
+	}
+
+	clock.Add(time.Minute)
+	if state := sender.CircuitState(); state != CircuitHalfOpen {
+		t.Fatalf("expect the circuit to be half-open, got %s", state)
+	}
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expect the probe to be sent and fail, got %v", err)
+	}
+	if state := sender.CircuitState(); state != CircuitOpen {
+		t.Fatalf("expect a failed probe to reopen the circuit, got %s", state)
+	}
+
+	clock.Add(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	abort = cancel
+	if _, err := sender.SendContext(ctx, NewMessage(nil, "1"), creds); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect the probe to be aborted, got %v", err)
+	}
+	abort = nil
+	if state := sender.CircuitState(); state != CircuitHalfOpen {
+		t.Fatalf("expect an aborted probe to keep the circuit half-open, got %s", state)
+	}
+
+	status = http.StatusOK
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); err != nil {
+		t.Fatalf("expect the probe to succeed: %v", err)
+	}
+	if state := sender.CircuitState(); state != CircuitClosed {
+		t.Fatalf("expect a successful probe to close the circuit, got %s", state)
+	}
+}
@@ -0,0 +1,221 @@
+package gcm
+
+import (
+	"time"
+)
+
+// MessageBuilder constructs a Message step by step. Each With method returns
+// the builder so that calls can be chained, and Build validates the result:
+//
+//	msg, err := gcm.NewMessageBuilder().
+//		AddToken(token).
+//		WithNotification("Greeting", "Hello, Android!").
+//		WithPriority(gcm.PriorityHigh).
+//		WithTTL(time.Hour).
+//		Build()
+//
+// Building a Message struct directly keeps working; the builder only reduces
+// boilerplate.
+type MessageBuilder struct {
+	msg Message
+	err error
+}
+
+// NewMessageBuilder returns a builder of an empty message.
+func NewMessageBuilder() *MessageBuilder {
+	return &MessageBuilder{}
+}
+
+// AddToken adds a registration token the message is sent to.
+func (b *MessageBuilder) AddToken(token string) *MessageBuilder {
+	b.msg.RegistrationIDs = append(b.msg.RegistrationIDs, token)
+	return b
+}
+
+// WithNotification sets the title and body of the notification.
+func (b *MessageBuilder) WithNotification(title, body string) *MessageBuilder {
+	b.msg.Notification.Title = title
+	b.msg.Notification.Body = body
+	return b
+}
+
+// WithAndroidNotification overrides the notification title and body on Android.
+func (b *MessageBuilder) WithAndroidNotification(title, body string) *MessageBuilder {
+	b.msg.SetAndroidNotification(title, body)
+	return b
+}
+
+// WithAPNSNotification overrides the notification title and body on iOS.
+func (b *MessageBuilder) WithAPNSNotification(title, body string) *MessageBuilder {
+	b.msg.SetAPNSNotification(title, body)
+	return b
+}
+
+// WithAPNSThreadID groups the notification with the others of the same
+// thread on iOS.
+func (b *MessageBuilder) WithAPNSThreadID(threadID string) *MessageBuilder {
+	b.msg.SetAPNSThreadID(threadID)
+	return b
+}
+
+// WithAPNSCategory sets the category selecting the action buttons of the
+// notification on iOS. See Message.SetAPNSCategory.
+func (b *MessageBuilder) WithAPNSCategory(category string) *MessageBuilder {
+	b.msg.SetAPNSCategory(category)
+	return b
+}
+
+// WithWebpushNotification overrides the notification title and body for web push.
+func (b *MessageBuilder) WithWebpushNotification(title, body string) *MessageBuilder {
+	b.msg.SetWebpushNotification(title, body)
+	return b
+}
+
+// WithLocalizedTitle sets the notification title to the localized string
+// of locKey, falling back to title on devices without the string. See
+// Message.SetLocalizedTitle.
+func (b *MessageBuilder) WithLocalizedTitle(title, locKey string, locArgs ...string) *MessageBuilder {
+	b.msg.SetLocalizedTitle(title, locKey, locArgs...)
+	return b
+}
+
+// WithLocalizedBody sets the notification body to the localized string of
+// locKey, falling back to body on devices without the string. See
+// Message.SetLocalizedBody.
+func (b *MessageBuilder) WithLocalizedBody(body, locKey string, locArgs ...string) *MessageBuilder {
+	b.msg.SetLocalizedBody(body, locKey, locArgs...)
+	return b
+}
+
+// WithSound sets the notification sound on Android and iOS, "default" or the
+// name of a sound resource of the app.
+func (b *MessageBuilder) WithSound(sound string) *MessageBuilder {
+	b.msg.SetSound(sound)
+	return b
+}
+
+// WithDefaultSound makes the notification play the default sound on
+// Android unless WithSound sets another one. See Message.SetDefaultSound.
+func (b *MessageBuilder) WithDefaultSound() *MessageBuilder {
+	b.msg.SetDefaultSound(true)
+	return b
+}
+
+// WithNotificationCount sets the number shown on the app icon by Android
+// launchers supporting it.
+func (b *MessageBuilder) WithNotificationCount(n int) *MessageBuilder {
+	b.msg.SetNotificationCount(n)
+	return b
+}
+
+// WithVisibility sets how much of the notification is shown on a secure lock
+// screen on Android.
+func (b *MessageBuilder) WithVisibility(visibility string) *MessageBuilder {
+	b.msg.SetVisibility(visibility)
+	return b
+}
+
+// WithTicker sets the text accessibility services announce when the
+// notification arrives on Android.
+func (b *MessageBuilder) WithTicker(ticker string) *MessageBuilder {
+	b.msg.SetTicker(ticker)
+	return b
+}
+
+// WithSubText sets the additional text shown below the notification body on
+// Android.
+func (b *MessageBuilder) WithSubText(subText string) *MessageBuilder {
+	b.msg.SetSubText(subText)
+	return b
+}
+
+// WithVibrateTimings sets the vibration pattern of the notification on Android.
+func (b *MessageBuilder) WithVibrateTimings(timings ...time.Duration) *MessageBuilder {
+	b.msg.SetVibrateTimings(timings...)
+	return b
+}
+
+// WithLightSettings sets the notification LED color and blinking on Android.
+func (b *MessageBuilder) WithLightSettings(color Color, on, off time.Duration) *MessageBuilder {
+	b.msg.SetLightSettings(color, on, off)
+	return b
+}
+
+// RequireNotification marks the message as a notification message, so that
+// Build fails unless a notification title or body is set. Without it an empty
+// notification is accepted as a data-only message.
+func (b *MessageBuilder) RequireNotification() *MessageBuilder {
+	b.msg.requireNotification = true
+	return b
+}
+
+// WithKind sets which of the notification and data objects are sent.
+func (b *MessageBuilder) WithKind(kind Kind) *MessageBuilder {
+	b.msg.Kind = kind
+	return b
+}
+
+// WithData sets the data payload. The map is copied.
+func (b *MessageBuilder) WithData(data map[string]interface{}) *MessageBuilder {
+	b.msg.Data = make(map[string]interface{}, len(data))
+	for k, v := range data {
+		b.msg.Data[k] = v
+	}
+	return b
+}
+
+// WithPriority sets the delivery priority, PriorityHigh or PriorityNormal.
+func (b *MessageBuilder) WithPriority(priority Priority) *MessageBuilder {
+	b.msg.Priority = priority
+	return b
+}
+
+// WithTTL sets how long the message is kept in FCM storage while the device is
+// offline. A zero duration is sent as "deliver now or drop". See
+// Message.SetTTL for the accepted durations; an invalid one is reported by
+// Build.
+func (b *MessageBuilder) WithTTL(d time.Duration) *MessageBuilder {
+	b.setErr(b.msg.SetTTL(d))
+	return b
+}
+
+// WithImmediateOrDrop sets a TTL of 0s: FCM delivers the message immediately
+// or drops it if the device is offline, e.g. for an incoming call. With
+// normal priority a device in Doze defers the delivery, which drops the
+// message too, so such messages are usually sent with high priority.
+func (b *MessageBuilder) WithImmediateOrDrop() *MessageBuilder {
+	b.msg.SetTimeToLive(0)
+	return b
+}
+
+// WithMaxStorage sets the longest TTL FCM accepts, 4 weeks, so that the
+// message is kept as long as possible while the device is offline. The
+// priority only decides whether the delivery may wake a device in Doze, so
+// normal priority is fine for messages that can wait.
+func (b *MessageBuilder) WithMaxStorage() *MessageBuilder {
+	b.msg.SetTimeToLive(maxTimeToLive)
+	return b
+}
+
+// setErr records the first error of the build steps.
+func (b *MessageBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Build returns the constructed message, or an error if it is not valid.
+// The message shares no state with the builder, so the builder can go on to
+// build further messages.
+func (b *MessageBuilder) Build() (*Message, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	msg := b.msg.Clone()
+	if err := msg.validate(); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,93 @@
+package gcm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageBuilder(t *testing.T) {
+	data := map[string]interface{}{"key": "value"}
+	msg, err := NewMessageBuilder().
+		AddToken("1").
+		AddToken("2").
+		WithNotification("title", "body").
+		WithData(data).
+		WithPriority("high").
+		WithTTL(time.Hour).
+		Build()
+	if err != nil {
+		t.Fatalf("expect Build() to be success: %v", err)
+	}
+
+	if len(msg.RegistrationIDs) != 2 {
+		t.Fatalf("expect 2 registration IDs, got %d", len(msg.RegistrationIDs))
+	}
+	if msg.Notification.Title != "title" || msg.Notification.Body != "body" {
+		t.Fatalf("unexpected notification: %+v", msg.Notification)
+	}
+	if msg.Priority != "high" {
+		t.Fatalf("expect priority high, got %q", msg.Priority)
+	}
+	if msg.TimeToLive != 3600 {
+		t.Fatalf("expect TimeToLive 3600, got %d", msg.TimeToLive)
+	}
+
+	data["key"] = "changed"
+	if msg.Data["key"] != "value" {
+		t.Fatalf("expect data to be copied")
+	}
+
+	if _, err := NewMessageBuilder().WithNotification("title", "body").Build(); err == nil {
+		t.Fatalf("expect Build() to be failed (missing token)")
+	}
+
+	if _, err := NewMessageBuilder().AddToken("1").WithTTL(500 * time.Millisecond).Build(); err == nil {
+		t.Fatalf("expect Build() to be failed (sub-second TTL)")
+	}
+
+	if _, err := NewMessageBuilder().AddToken("1").RequireNotification().WithData(data).Build(); err == nil {
+		t.Fatalf("expect Build() to be failed (empty notification)")
+	}
+
+	if _, err := NewMessageBuilder().AddToken("1").RequireNotification().WithNotification("", "body").Build(); err != nil {
+		t.Fatalf("expect Build() to be success: %v", err)
+	}
+
+	if _, err := NewMessageBuilder().AddToken("1").WithData(data).Build(); err != nil {
+		t.Fatalf("expect Build() of a data-only message to be success: %v", err)
+	}
+
+	if _, err := NewMessageBuilder().AddToken("1").WithKind(KindData).WithData(data).Build(); err != nil {
+		t.Fatalf("expect Build() of a data kind message to be success: %v", err)
+	}
+
+	msg, err = NewMessageBuilder().AddToken("1").
+		WithLocalizedTitle("New message", "new_message_title").
+		WithLocalizedBody("You have a new message", "new_message_body", "Alice").
+		Build()
+	if err != nil {
+		t.Fatalf("expect Build() of a localized message to be success: %v", err)
+	}
+	if msg.Notification.Body != "You have a new message" || msg.Android.Notification.BodyLocKey != "new_message_body" {
+		t.Fatalf("expect the default and the loc key to be set, got %+v and %+v", msg.Notification, msg.Android.Notification)
+	}
+
+	msg, err = NewMessageBuilder().AddToken("1").WithNotification("", "hello").WithNotificationCount(3).Build()
+	if err != nil || *msg.Android.Notification.NotificationCount != 3 {
+		t.Fatalf("expect the notification count to be set, got %v", err)
+	}
+
+	msg, err = NewMessageBuilder().AddToken("1").WithPriority("high").WithImmediateOrDrop().Build()
+	if err != nil || newAndroid(msg).TTL != "0s" {
+		t.Fatalf("expect a TTL of 0s, got %v", err)
+	}
+
+	msg, err = NewMessageBuilder().AddToken("1").WithMaxStorage().Build()
+	if err != nil || newAndroid(msg).TTL != "2419200s" {
+		t.Fatalf("expect a TTL of 4 weeks, got %v", err)
+	}
+
+	if _, err := NewMessageBuilder().AddToken("1").WithPriority("urgent").Build(); err == nil {
+		t.Fatalf("expect Build() to be failed (invalid priority)")
+	}
+}
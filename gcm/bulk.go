@@ -0,0 +1,131 @@
+package gcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// SendToAll sends msg to tokens, which may be many more than a message
+// accepts: the tokens are split into chunks of MaxRegistrationIDs tokens
+// that are sent concurrently by StreamWorkers workers, each chunk like with
+// Send, so that the rate limit and the retries of the client apply. The
+// RegistrationIDs of msg are ignored. Every chunk is validated before
+// anything is sent.
+//
+// The returned Response aggregates the Results of all tokens in the order of
+// tokens. A failed token gets a Result with its error, and the other tokens
+// of its chunk are still sent, as with SendEach. When a chunk fails as a
+// whole, e.g. as the access token can not be fetched, the Results of all its
+// tokens carry the error. The returned error tells the outcome as
+// Response.Err does, wrapping the errors of the failed tokens and chunks:
+// nil when every token succeeded, ErrPartialFailure when some failed and
+// ErrAllFailed when all did, along with the Response in both cases. See
+// WithProgress to follow the progress.
+func (c *Client) SendToAll(tokens []string, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	if msg == nil {
+		return nil, &ValidationError{Field: "Message", Reason: "the message must not be nil"}
+	}
+
+	size := c.MaxRegistrationIDs
+	if size <= 0 {
+		size = maxSendTokens
+	}
+	var chunks []*Message
+	var sizes []int
+	for start := 0; start < len(tokens); start += size {
+		end := start + size
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		chunk := *msg
+		chunk.RegistrationIDs = tokens[start:end]
+		normalized := c.normalizeTokens(&chunk)
+		if err := c.validate(normalized); err != nil {
+			return nil, fmt.Errorf("tokens %d to %d: %w", start, end-1, err)
+		}
+		chunks = append(chunks, normalized)
+		sizes = append(sizes, end-start)
+	}
+	if len(chunks) == 0 {
+		return nil, &ValidationError{Field: "tokens", Reason: "must not be empty"}
+	}
+
+	o := newSendOptions(opts)
+	done := 0
+	responses, errs := c.sendConcurrently(chunks, acsJsonData, o, func(i int) {
+		done += sizes[i]
+		if o.onProgress != nil {
+			o.onProgress(done, len(tokens))
+		}
+	})
+
+	response := mergeResponses(chunks, responses, errs)
+	response.Validated = o.validateOnly(msg)
+	return response, response.err(errors.Join(errs...))
+}
+
+// sendConcurrently sends each of msgs like SendEach with StreamWorkers
+// workers and returns the Response and the error of each of them; the
+// Response is nil if the send failed as a whole. onDone, if not nil, is
+// called after each send with its index; the calls are not concurrent.
+func (c *Client) sendConcurrently(msgs []*Message, acsJsonData []byte, o *sendOptions, onDone func(i int)) ([]*Response, []error) {
+	workers := c.StreamWorkers
+	if workers <= 0 {
+		workers = defaultStreamWorkers
+	}
+
+	responses := make([]*Response, len(msgs))
+	errs := make([]error, len(msgs))
+	var mu sync.Mutex
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				responses[i], errs[i] = c.sendEach(context.Background(), msgs[i], acsJsonData, o)
+
+				if onDone != nil {
+					mu.Lock()
+					onDone(i)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range msgs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return responses, errs
+}
+
+// mergeResponses aggregates the responses of the sends of msgs in order, with
+// the error Results of the tokens of the sends that failed as a whole.
+func mergeResponses(msgs []*Message, responses []*Response, errs []error) *Response {
+	response := &Response{}
+	for i, msg := range msgs {
+		if responses[i] == nil {
+			for _, token := range msg.RegistrationIDs {
+				result := newErrorResult(token, errs[i])
+				result.CorrelationID = msg.CorrelationID
+				result.Meta = msg.Meta
+				response.Results = append(response.Results, result)
+			}
+			response.FailureCount += len(msg.RegistrationIDs)
+			continue
+		}
+
+		response.Results = append(response.Results, responses[i].Results...)
+		response.FailureCount += responses[i].FailureCount
+		response.InvalidTokens = append(response.InvalidTokens, responses[i].InvalidTokens...)
+		response.SenderIDMismatchTokens = append(response.SenderIDMismatchTokens, responses[i].SenderIDMismatchTokens...)
+	}
+	return response
+}
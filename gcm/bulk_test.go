@@ -0,0 +1,109 @@
+package gcm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSendToAll(t *testing.T) {
+	var mu sync.Mutex
+	sent := map[string]bool{}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		mu.Lock()
+		sent[received.Message.Token] = true
+		mu.Unlock()
+		if received.Message.Token == "broken" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"name":"projects/test/messages/%s"}`, received.Message.Token)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.MaxRegistrationIDs = 3
+	sender.StreamWorkers = 2
+	creds := testCredentials(t, server.URL+"/token")
+
+	tokens := make([]string, 10)
+	for i := range tokens {
+		tokens[i] = strconv.Itoa(i)
+	}
+	tokens[7] = "broken"
+
+	var progress []int
+	resp, err := sender.SendToAll(tokens, NewMessage(nil), creds, WithProgress(func(done, total int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if total != len(tokens) {
+			t.Errorf("expect the total to be %d, got %d", len(tokens), total)
+		}
+		progress = append(progress, done)
+	}))
+	if !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("expect ErrPartialFailure for the broken chunk, got %v", err)
+	}
+	var fcmErr *FCMError
+	if !errors.As(err, &fcmErr) || fcmErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expect the error of the broken chunk, got %v", err)
+	}
+
+	if len(resp.Results) != len(tokens) {
+		t.Fatalf("expect a result per token, got %d", len(resp.Results))
+	}
+	for i, result := range resp.Results {
+		if result.Token != tokens[i] {
+			t.Fatalf("#%d expect the results in the order of the tokens, got %s", i, result.Token)
+		}
+	}
+	if resp.FailureCount != 1 || resp.Results[7].Error == "" {
+		t.Fatalf("expect only the broken token to fail, got %+v", resp)
+	}
+	for _, i := range []int{6, 8} {
+		if want := "projects/test/messages/" + tokens[i]; resp.Results[i].MessageID != want {
+			t.Fatalf("#%d expect the token of the broken chunk to be sent, got %+v", i, resp.Results[i])
+		}
+	}
+	mu.Lock()
+	sentAfter := sent["8"]
+	mu.Unlock()
+	if !sentAfter {
+		t.Fatalf("expect the token after the broken one to reach the server")
+	}
+
+	if len(progress) != 4 || progress[len(progress)-1] != len(tokens) {
+		t.Fatalf("expect a progress report per chunk up to %d, got %v", len(tokens), progress)
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i] <= progress[i-1] {
+			t.Fatalf("expect the progress to increase, got %v", progress)
+		}
+	}
+
+	if _, err := sender.SendToAll([]string{"broken"}, NewMessage(nil), creds); !errors.Is(err, ErrAllFailed) || errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("expect ErrAllFailed, got %v", err)
+	}
+	if _, err := sender.SendToAll(tokens[:3], NewMessage(nil), creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+}
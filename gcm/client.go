@@ -1,16 +1,46 @@
 package gcm
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/time/rate"
 )
 
+const (
+	// Version is the version of this package sent in the User-Agent header.
+	// It is bumped along with gaurun.Version, which a test of the gaurun
+	// package checks.
+	Version = "0.13.1"
+
+	// defaultUserAgent is the User-Agent header of requests unless overridden
+	// by Client.UserAgent.
+	defaultUserAgent = "gaurun-gcm/" + Version
+)
+
+const (
+	// FCMBatchEndpoint is the endpoint accepting multipart/mixed batches of
+	// FCM HTTP v1 send requests.
+	// See more on https://firebase.google.com/docs/cloud-messaging/send-message#send-a-batch-of-messages
+	FCMBatchEndpoint = "https://fcm.googleapis.com/batch"
+)
+
+// MessagingScope is the OAuth2 scope of the access tokens used to send
+// messages. It may be overridden, e.g. with
+// "https://www.googleapis.com/auth/cloud-platform", before creating clients.
+var MessagingScope = "https://www.googleapis.com/auth/firebase.messaging"
+
 // const (
 // FCMSendEndpoint is the endpoint for sending message to the Firebase Cloud Messaging (FCM) server.
 // See more on https://firebase.google.com/docs/cloud-messaging/server
@@ -18,15 +48,58 @@ import (
 // )
 
 const (
-	// fcmPushPriorityHigh and fcmPushPriorityNormal is priority of a delivery message options
-	// See more on https://firebase.google.com/docs/cloud-messaging/concept-options?hl=en#setting-the-priority-of-a-message
-	fcmPushPriorityHigh   = "high"
-	fcmPushPriorityNormal = "normal"
+	// apnsPriorityHeader is the APNs header for the priority of a notification.
+	// See more on https://developer.apple.com/documentation/usernotifications/setting_up_a_remote_notification_server/sending_notification_requests_to_apns
+	apnsPriorityHeader = "apns-priority"
+	apnsPriorityHigh   = "10"
+	apnsPriorityLow    = "5"
+
+	// apnsCollapseIDHeader and apnsExpirationHeader are the APNs headers
+	// collapsing notifications and limiting how long APNs stores them.
+	apnsCollapseIDHeader = "apns-collapse-id"
+	apnsExpirationHeader = "apns-expiration"
+
+	// apnsPushTypeHeader is the APNs header for the type of a notification,
+	// which Apple requires since iOS 13.
+	apnsPushTypeHeader     = "apns-push-type"
+	apnsPushTypeAlert      = "alert"
+	apnsPushTypeBackground = "background"
+
+	// maxAPNSCollapseIDLength is the max length in bytes of apns-collapse-id.
+	maxAPNSCollapseIDLength = 64
+)
+
+// The number of registration tokens one operation accepts differs between
+// the FCM APIs, so each operation checks its own limit.
+const (
+	// maxSendTokens is the default max number of registration IDs of a
+	// message sent with Send, overridden by Client.MaxRegistrationIDs. It is
+	// a limit of the legacy API; v1 sends one request per ID.
+	maxSendTokens = 1000
+
+	// maxBatchTokens is max number of sub-requests in one batch request.
+	// SendBatch splits larger messages into several batch requests.
+	maxBatchTokens = 500
+
+	// maxTopicManagementTokens is max number of registration tokens in one
+	// topic subscription request of the Instance ID API.
+	maxTopicManagementTokens = 1000
 )
 
 const (
-	// maxRegistrationIDs are max number of registration IDs in one message.
-	maxRegistrationIDs = 1000
+	// defaultStreamWorkers is the default number of SendStream workers.
+	defaultStreamWorkers = 10
+
+	// defaultMaxResponseBodySize is the default of MaxResponseBodySize. FCM
+	// responses are tiny, but batch responses hold a part per message.
+	defaultMaxResponseBodySize = 4 << 20
+
+	// autoCollapseKeyLength is the length of the collapse keys generated by
+	// WithAutoCollapse. It is shorter than apns-collapse-id's limit of 64.
+	autoCollapseKeyLength = 32
+
+	// healthCheckToken is the dummy registration token HealthCheck sends to.
+	healthCheckToken = "gaurun-health-check"
 
 	// maxTimeToLive is max time FCM storage can store messages when the device is offline
 	maxTimeToLive = 2419200 // 4 weeks
@@ -38,16 +111,145 @@ const (
 // requests on the application server's behalf. To send a message to one or
 // more devices use the Client's Send methods.
 type Client struct {
-	ApiKey string
-	URL    string
-	Http   *http.Client
+	ApiKey   string
+	URL      string
+	BatchURL string
+	IIDURL   string
+	Http     *http.Client
+
+	// MaxRegistrationIDs caps the number of registration IDs of a message,
+	// i.e. the number of v1 requests one Send fans out to. Zero (the default)
+	// means 1000.
+	MaxRegistrationIDs int
+
+	// MaxRetries is the number of times a request failing with a transient
+	// error (a network error, 429 or 5xx) is retried. Zero (the default)
+	// disables retrying.
+	MaxRetries int
+
+	// Backoff decides how long to wait before each retry. If nil,
+	// DefaultBackoff is used. A longer Retry-After delay of the FCM server
+	// is waited for instead, up to a minute.
+	Backoff Backoff
+
+	// StringifyData makes the client accept boolean and numeric Data values
+	// and send them as strings. By default any value other than a string is
+	// rejected before sending, as FCM only accepts string values.
+	StringifyData bool
+
+	// PruneUnregistered makes sending to an unregistered token (FCM reports
+	// UNREGISTERED or NOT_FOUND) or to a token of another project
+	// (SENDER_ID_MISMATCH) a distinct outcome rather than a failure: Send
+	// records the error code in the token's Result and goes on with the
+	// other tokens instead of returning an error, and the token is listed
+	// in Response.InvalidTokens instead of being counted in
+	// Response.FailureCount. The tokens of another project are listed in
+	// Response.SenderIDMismatchTokens too.
+	PruneUnregistered bool
+
+	// DropEmptyTokens makes the client skip registration IDs that are empty
+	// or only whitespace instead of rejecting the message. The Response then
+	// has no Result for them. Surrounding whitespace is always trimmed.
+	DropEmptyTokens bool
+
+	// RejectDataConflicts makes the client reject Data keys FCM reserves,
+	// e.g. "from" or "google.*", and keys colliding with a notification
+	// field, e.g. "title" on a message with a notification, which client
+	// apps may render instead of the notification. Off by default, as such
+	// keys can be intended.
+	RejectDataConflicts bool
+
+	// StrictValidation makes the client reject the settings it would
+	// otherwise send as they are or silently override or drop:
+	//   - Data of a KindNotification and Notification of a KindData message
+	//   - DelayWhileIdle, which the FCM HTTP v1 API does not support
+	//   - CollapseKey, Priority, RestrictedPackageName and TimeToLive
+	//     differing from the Android values overriding them
+	//   - an Android.TTL that is not a duration like "3600s"
+	//   - Android light settings color components outside 0 to 1
+	//   - APNs content-available and mutable-content other than 0 or 1
+	StrictValidation bool
+
+	// VerifyImageURL makes the client check before sending a message with an
+	// image, e.g. Notification.Image, that the image URL responds to a HEAD
+	// request with an image content type within 3 seconds, so that a broken
+	// image does not silently degrade the notification. It is off by default
+	// as it delays each send by the request; a failed check fails the send
+	// with a ValidationError.
+	VerifyImageURL bool
+
+	// GzipThreshold enables gzip compression of request bodies larger than
+	// this many bytes. Zero (the default) disables compression.
+	GzipThreshold int
+
+	// MaxResponseBodySize is the max number of bytes read from the body of
+	// a response, 4 MiB if zero. Reading a larger body fails with
+	// ErrResponseTooLarge, so that an unexpected upstream response, e.g. of
+	// a misbehaving proxy, can not exhaust the memory.
+	MaxResponseBodySize int64
+
+	// DefaultData is merged into the Data of every message sent, e.g. with
+	// the app version or the environment. The keys of a message's own Data
+	// win on conflict. The Data of the messages is not modified.
+	DefaultData map[string]string
+
+	// SignRequest, if set, is called with each send request to FCM and its
+	// body, compressed if it is, right before it is posted, e.g. to add the
+	// HMAC header a signing proxy requires. It must not remove or change the
+	// Authorization header, nor modify or keep body. An error fails the
+	// request without posting it.
+	SignRequest func(req *http.Request, body []byte) error
+
+	// OnWarning, if set, is called for each allowed but likely unintended
+	// setting of a message before it is sent.
+	OnWarning func(msg *Message, warning string)
+
+	// IgnoreOKErrorBody makes a 200 response count as a success whatever
+	// its body. By default a 200 response whose body holds an error object,
+	// as a proxy or a batch sub-response may wrap a failure in, fails the
+	// send to its token with an FCMError like a non-200 response does.
+	IgnoreOKErrorBody bool
+
+	// StreamWorkers is the number of messages SendStream sends concurrently.
+	// Zero (the default) means 10.
+	StreamWorkers int
+
+	// UserAgent is sent as the User-Agent header of every request. If empty,
+	// "gaurun-gcm/<Version>" is used.
+	UserAgent string
+
+	limiter  *rate.Limiter
+	inFlight chan struct{}
+	breaker  *circuitBreaker
+	clock    Clock
+
+	// newSource returns a new token source of NewClientWithTokenSource or
+	// NewClientWithDefaultCredentials, used to send without a service
+	// account JSON. It is called again after RefreshToken.
+	newSource func() (oauth2.TokenSource, error)
+
+	mu           sync.Mutex
+	tokenSources map[string]oauth2.TokenSource // keyed by service account JSON
+	closed       bool
+}
+
+// ClientOption configures a Client created by NewClient.
+type ClientOption func(*Client)
+
+// WithTransport makes the client send its requests, including the access
+// token requests, through rt. It is useful for fault injection, recording
+// or proxying.
+func WithTransport(rt http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.Http = &http.Client{Transport: rt}
+	}
 }
 
 // NewClient returns a new sender with the given URL and apiKey.
 // If one of input is empty or URL is malformed, returns error.
 // It sets http.DefaultHTTP client for http connection to server.
-// If you need our own configuration overwrite it.
-func NewClient(urlString, apiKey string) (*Client, error) {
+// If you need our own configuration overwrite it or pass options.
+func NewClient(urlString, apiKey string, opts ...ClientOption) (*Client, error) {
 	if len(urlString) == 0 {
 		return nil, fmt.Errorf("missing FCM endpoint url")
 	}
@@ -56,127 +258,874 @@ func NewClient(urlString, apiKey string) (*Client, error) {
 		return nil, fmt.Errorf("missing API Key")
 	}
 
+	c, err := newClient(urlString, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.ApiKey = apiKey
+	return c, nil
+}
+
+// NewClientWithTokenSource returns a new sender with the given URL fetching
+// its access tokens from ts, e.g. the credentials of GKE workload identity
+// or of an impersonated service account, instead of a service account JSON.
+// Its sends may then pass nil as acsJsonData; a service account JSON passed
+// to a send is still used for that send. The tokens of ts are cached until
+// they expire, see RefreshToken.
+func NewClientWithTokenSource(urlString string, ts oauth2.TokenSource, opts ...ClientOption) (*Client, error) {
+	if len(urlString) == 0 {
+		return nil, fmt.Errorf("missing FCM endpoint url")
+	}
+
+	if ts == nil {
+		return nil, fmt.Errorf("missing token source")
+	}
+
+	c, err := newClient(urlString, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.newSource = func() (oauth2.TokenSource, error) {
+		return oauth2.ReuseTokenSource(nil, ts), nil
+	}
+	return c, nil
+}
+
+// NewClientWithDefaultCredentials returns a new sender with the given URL
+// using the Application Default Credentials: the key file named by the
+// GOOGLE_APPLICATION_CREDENTIALS environment variable or of gcloud, e.g.
+// locally, else the service account of the metadata server on GCE, GKE or
+// Cloud Run. Its sends may then pass nil as acsJsonData, see
+// NewClientWithTokenSource. It fails if the credentials are of another
+// project than the one of the URL, when both are known.
+func NewClientWithDefaultCredentials(urlString string, opts ...ClientOption) (*Client, error) {
+	if len(urlString) == 0 {
+		return nil, fmt.Errorf("missing FCM endpoint url")
+	}
+
+	c, err := newClient(urlString, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, c.Http)
+	creds, err := google.FindDefaultCredentials(ctx, MessagingScope)
+	if err != nil {
+		return nil, fmt.Errorf("error finding the default credentials: %v", err)
+	}
+	if project := endpointProject(urlString); project != "" && creds.ProjectID != "" && project != creds.ProjectID {
+		return nil, fmt.Errorf("the default credentials are of the project %q, not of the project %q of the endpoint", creds.ProjectID, project)
+	}
+
+	c.newSource = func() (oauth2.TokenSource, error) {
+		// Finding the credentials again makes a token source without the
+		// token cached by the previous one.
+		creds, err := google.FindDefaultCredentials(ctx, MessagingScope)
+		if err != nil {
+			return nil, fmt.Errorf("error finding the default credentials: %v", err)
+		}
+		return creds.TokenSource, nil
+	}
+	return c, nil
+}
+
+func newClient(urlString string, opts []ClientOption) (*Client, error) {
 	if _, err := url.Parse(urlString); err != nil {
 		return nil, fmt.Errorf("failed to parse URL %q: %s", urlString, err)
 	}
 
-	return &Client{
-		URL:    urlString,
-		ApiKey: apiKey,
-		Http:   http.DefaultClient,
-	}, nil
+	c := &Client{
+		URL:      urlString,
+		BatchURL: FCMBatchEndpoint,
+		IIDURL:   IIDEndpoint,
+		Http:     http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.breaker != nil {
+		c.breaker.clock = c.now
+	}
+
+	return c, nil
+}
+
+// Send sends a message to the FCM server. One v1 request is issued per
+// registration ID. A request failing with a transient error, see
+// IsRetryable, is retried up to MaxRetries times, waiting as Backoff decides
+// between the attempts; by default it is not retried. A non-nil error is
+// returned if a request still fails (i.e. if the response status is not
+// "200 OK").
+// The returned Response holds one Result per registration ID carrying the
+// token and the message name FCM assigned to it.
+func (c *Client) Send(msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	return c.SendContext(context.Background(), msg, acsJsonData, opts...)
+}
+
+// SendContext is like Send but aborts the requests and the waits between
+// retries when ctx is done.
+func (c *Client) SendContext(ctx context.Context, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	msg = c.normalizeTokens(msg)
+	if err := c.validate(msg); err != nil {
+		return nil, err
+	}
+
+	return c.send(ctx, msg, acsJsonData, newSendOptions(opts))
+}
+
+// SendWithTimeout is like SendContext with a context timing out after d.
+// When the send times out the error matches context.DeadlineExceeded.
+func (c *Client) SendWithTimeout(d time.Duration, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return c.SendContext(ctx, msg, acsJsonData, opts...)
+}
+
+// SendEach sends msg to each of its registration IDs like Send, but goes on
+// after a token fails and returns the failures of all tokens joined with
+// errors.Join, each a *TokenError wrapping the error of the token, e.g. an
+// *FCMError. A nil error means that msg was sent to every token, except for
+// the ones pruned with PruneUnregistered. An invalid message fails before
+// anything is sent, with the validation error.
+func (c *Client) SendEach(msg *Message, acsJsonData []byte, opts ...SendOption) error {
+	msg = c.normalizeTokens(msg)
+	if err := c.validate(msg); err != nil {
+		return err
+	}
+
+	_, err := c.sendEach(context.Background(), msg, acsJsonData, newSendOptions(opts))
+	return err
+}
+
+// sendEach is like send but goes on after a token fails, as SendEach does:
+// the failed tokens get error Results and their errors are returned joined,
+// each a *TokenError, along with the Response. An error failing the send as
+// a whole, e.g. of the access token, is returned with a nil Response.
+func (c *Client) sendEach(ctx context.Context, msg *Message, acsJsonData []byte, o *sendOptions) (*Response, error) {
+	var errs []error
+	each := *o
+	each.onTokenError = func(token string, err error) {
+		errs = append(errs, &TokenError{Token: token, Err: err})
+	}
+	response, err := c.send(ctx, msg, acsJsonData, &each)
+	if err != nil {
+		return nil, err
+	}
+	return response, errors.Join(errs...)
+}
+
+// SendOne sends a message to the single registration token. msg must not
+// specify other registration IDs; it may leave RegistrationIDs empty. The
+// returned Response holds exactly one Result. When FCM rejects the message
+// the error is an *FCMError.
+func (c *Client) SendOne(token string, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	oneMsg, err := oneMessage(token, msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.validate(oneMsg); err != nil {
+		return nil, err
+	}
+
+	return c.send(context.Background(), oneMsg, acsJsonData, newSendOptions(opts))
+}
+
+// oneMessage returns a copy of msg sent to the trimmed token only, see
+// SendOne.
+func oneMessage(token string, msg *Message) (*Message, error) {
+	token = strings.TrimSpace(token)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("the registration token must not be empty")
+	}
+
+	if msg == nil {
+		return nil, fmt.Errorf("the message must not be nil")
+	}
+
+	if len(msg.RegistrationIDs) > 1 || (len(msg.RegistrationIDs) == 1 && strings.TrimSpace(msg.RegistrationIDs[0]) != token) {
+		return nil, fmt.Errorf("SendOne sends to exactly one target but the message specifies other registration IDs")
+	}
+
+	oneMsg := *msg
+	oneMsg.RegistrationIDs = []string{token}
+	return &oneMsg, nil
+}
+
+// SendSingle sends msg to a single registration token like SendOne and
+// returns the outcome as a SendResult, whose Err is non-nil when the send
+// failed:
+//
+//	if err := c.SendSingle(token, msg, acsJsonData).Err(); err != nil {
+//		var result *gcm.SendResult
+//		errors.As(err, &result) // result.ErrorCode == "UNREGISTERED"
+//	}
+func (c *Client) SendSingle(token string, msg *Message, acsJsonData []byte, opts ...SendOption) *SendResult {
+	resp, err := c.SendOne(token, msg, acsJsonData, opts...)
+	return newSendResult(strings.TrimSpace(token), resp, err)
+}
+
+// SendToDeviceGroup sends a message to the devices of a device group
+// identified by notificationKey. FCM v1 accepts the group's notification key
+// in place of a registration token; the RegistrationIDs of msg are ignored.
+// A non-nil error is returned if the group key is empty, the message is
+// invalid or FCM rejects the message.
+func (c *Client) SendToDeviceGroup(notificationKey string, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	notificationKey = strings.TrimSpace(notificationKey)
+	if len(notificationKey) == 0 {
+		return nil, fmt.Errorf("the notification key must not be empty")
+	}
+
+	if msg == nil {
+		return nil, fmt.Errorf("the message must not be nil")
+	}
+
+	groupMsg := *msg
+	groupMsg.RegistrationIDs = []string{notificationKey}
+	if err := c.validate(&groupMsg); err != nil {
+		return nil, err
+	}
+
+	return c.send(context.Background(), &groupMsg, acsJsonData, newSendOptions(opts))
 }
 
-// Send sends a message to the FCM server without retrying in case of
-// service unavailability. A non-nil error is returned if a non-recoverable
-// error occurs (i.e. if the response status is not "200 OK").
-func (c *Client) Send(msg *Message, acsJsonData []byte) (*Response, error) {
-	if err := msg.validate(); err != nil {
+// SendToTopics sends msg to the devices subscribed to all of topics with
+// matchAll, else to any of them, building the FCM condition from the topic
+// names, e.g. "'news' in topics && 'sports' in topics". At most 5 topics are
+// accepted. The RegistrationIDs, Topic and Condition of msg are ignored.
+func (c *Client) SendToTopics(topics []string, matchAll bool, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	if msg == nil {
+		return nil, fmt.Errorf("the message must not be nil")
+	}
+	condition, err := topicsCondition(topics, matchAll)
+	if err != nil {
+		return nil, err
+	}
+
+	topicsMsg := *msg
+	topicsMsg.RegistrationIDs = nil
+	topicsMsg.Topic = ""
+	topicsMsg.Condition = condition
+	if err := c.validate(&topicsMsg); err != nil {
 		return nil, err
 	}
 
-	return c.send(msg, acsJsonData)
+	return c.send(context.Background(), &topicsMsg, acsJsonData, newSendOptions(opts))
 }
 
-func (c *Client) send(msg *Message, acsJsonData []byte) (*Response, error) {
-	var buf bytes.Buffer
+// SendRaw sends message, the JSON of an FCM HTTP v1 message, as it is: it is
+// wrapped in {"message": ...} and posted without being validated beyond
+// being a non-empty JSON object, so that fields this package does not model
+// yet can be sent. The Response holds a single Result whose Token is the
+// "token" of message, if any.
+func (c *Client) SendRaw(ctx context.Context, message json.RawMessage, acsJsonData []byte) (*Response, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(message, &fields); err != nil {
+		return nil, &ValidationError{Field: "raw message", Reason: fmt.Sprintf("must be a JSON object: %v", err)}
+	}
+	if len(fields) == 0 {
+		return nil, &ValidationError{Field: "raw message", Reason: "must not be empty"}
+	}
+	var token string
+	if raw, ok := fields["token"]; ok {
+		json.Unmarshal(raw, &token)
+	}
 
-	//oldJsonData, _ := json.Marshal(*msg)
-	//fmt.Printf("旧送信JSON(Android):%s\n\n", string(oldJsonData))
+	body := getBuffer()
+	defer body.release()
+	if err := body.encode(struct {
+		Message json.RawMessage `json:"message"`
+	}{message}); err != nil {
+		return nil, err
+	}
 
-	var responses []Response
+	start := c.now()
+	acsToken, err := c.accessToken(ctx, acsJsonData)
+	if err != nil {
+		return nil, err
+	}
+	response := &Response{TokenLatency: c.now().Sub(start)}
 
-	acsToken, err := getAcsessToken(acsJsonData)
+	result, err := c.postBody(ctx, acsToken, token, body, false, &sendOptions{})
 	if err != nil {
 		return nil, err
 	}
+	response.Results = append(response.Results, *result)
+	response.classify(c.PruneUnregistered, false)
+
+	return response, nil
+}
+
+// Marshal returns the JSON request body Send would post for msg with the
+// given options, without sending it, e.g. for snapshot tests of message
+// templates. The body is the one of the first registration ID of msg; those
+// of the other registration IDs only differ in the token. msg is validated
+// like with Send.
+func (c *Client) Marshal(msg *Message, opts ...SendOption) ([]byte, error) {
+	msg = c.normalizeTokens(msg)
+	if err := c.validate(msg); err != nil {
+		return nil, err
+	}
+
+	o := newSendOptions(opts)
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	validateOnly := o.validateOnly(msg)
+	msg = o.prepare(c.withDefaultData(msg))
+
+	return json.Marshal(WrappedMessage{ValidateOnly: validateOnly, Message: newMessageV1(msg, msg.targets()[0], c.now())})
+}
+
+// SendDryRunAll validates msg for each of its RegistrationIDs without
+// delivering anything: every request is sent with validate_only set, so no
+// device receives a notification. It returns the tokens FCM accepts and the
+// tokens FCM reports as unregistered, belonging to another project or
+// malformed, which can be removed from a token store. A non-nil error is
+// returned if the message is invalid or a request fails for a reason other
+// than the token, including an INVALID_ARGUMENT error about the rest of the
+// message, so that a rejected message does not mark its tokens invalid.
+func (c *Client) SendDryRunAll(msg *Message, acsJsonData []byte) (valid, invalid []string, err error) {
+	msg = c.normalizeTokens(msg)
+	if err := c.validate(msg); err != nil {
+		return nil, nil, err
+	}
+
+	acsToken, err := c.accessToken(context.Background(), acsJsonData)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	for _, token := range msg.RegistrationIDs {
-		messageV1 := MessageV1{
-			Token:                 token,
-			CollapseKey:           msg.CollapseKey,
-			Data:                  msg.Data,
-			DelayWhileIdle:        msg.DelayWhileIdle,
-			TimeToLive:            msg.TimeToLive,
-			RestrictedPackageName: msg.RestrictedPackageName,
-			DryRun:                msg.DryRun,
-		}
-		messageV1.Notification.Title = msg.Notification.Title
-		messageV1.Notification.Body = msg.Notification.Body
-		messageV1.Android.Notification.Tag = msg.Notification.Tag
-		messageV1.Android.Notification.ClickAction = msg.Notification.ClickAction
-		messageV1.Android.Priority = msg.Priority
-
-		wrappedMsg := WrappedMessage{messageV1}
-
-		//jsonData, err := json.Marshal(wrappedMsg)
-		//if err != nil {
-		//	fmt.Println("Error marshaling to new JSON:", err)
-		//	return nil, err
-		//}
-		//fmt.Printf("送信JSON(Android):%s\n\n", string(jsonData))
-
-		encoder := json.NewEncoder(&buf)
-		if err := encoder.Encode(wrappedMsg); err != nil {
-			return nil, err
+		wrappedMsg := WrappedMessage{ValidateOnly: true, Message: newMessageV1(msg, token, c.now())}
+		if _, err := c.post(context.Background(), acsToken, wrappedMsg, &sendOptions{}); err != nil {
+			if !isPrunable(err) && !isInvalidTokenArgument(err) {
+				return nil, nil, err
+			}
+			invalid = append(invalid, token)
+			continue
 		}
+		valid = append(valid, token)
+	}
 
-		req, err := http.NewRequest("POST", c.URL, &buf)
-		if err != nil {
-			return nil, err
+	return valid, invalid, nil
+}
+
+// HealthCheck verifies that an access token can be fetched for acsJsonData
+// and that the FCM server accepts it, without delivering anything: it sends
+// a validate_only request to a dummy registration token. FCM rejecting the
+// dummy token still proves authentication and connectivity, so only the
+// other errors are returned, e.g. one matching ErrUnauthorized.
+func (c *Client) HealthCheck(ctx context.Context, acsJsonData []byte) error {
+	acsToken, err := c.accessToken(ctx, acsJsonData)
+	if err != nil {
+		return err
+	}
+
+	wrappedMsg := WrappedMessage{ValidateOnly: true, Message: newMessageV1(&Message{}, healthCheckToken, c.now())}
+	if _, err := c.post(ctx, acsToken, wrappedMsg, &sendOptions{}); err != nil && !isInvalidToken(err) {
+		return err
+	}
+
+	return nil
+}
+
+// normalizeTokens returns msg with the surrounding whitespace of its
+// registration IDs trimmed and, with DropEmptyTokens, the empty ones
+// removed. msg is returned as is if it needs no change, and is not modified
+// otherwise.
+func (c *Client) normalizeTokens(msg *Message) *Message {
+	if msg == nil {
+		return nil
+	}
+
+	changed := false
+	for _, token := range msg.RegistrationIDs {
+		trimmed := strings.TrimSpace(token)
+		if trimmed != token || (c.DropEmptyTokens && trimmed == "") {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return msg
+	}
+
+	normalized := *msg
+	normalized.RegistrationIDs = make([]string, 0, len(msg.RegistrationIDs))
+	for _, token := range msg.RegistrationIDs {
+		token = strings.TrimSpace(token)
+		if c.DropEmptyTokens && token == "" {
+			continue
+		}
+		normalized.RegistrationIDs = append(normalized.RegistrationIDs, token)
+	}
+	return &normalized
+}
+
+// withDefaultData returns msg with DefaultData merged into its Data: msg
+// itself if there is no default data, else a copy with new Data.
+func (c *Client) withDefaultData(msg *Message) *Message {
+	if len(c.DefaultData) == 0 {
+		return msg
+	}
+
+	merged := *msg
+	merged.Data = make(map[string]interface{}, len(c.DefaultData)+len(msg.Data))
+	for k, v := range c.DefaultData {
+		merged.Data[k] = v
+	}
+	for k, v := range msg.Data {
+		merged.Data[k] = v
+	}
+	return &merged
+}
+
+// validate validates msg and reports its warnings to OnWarning.
+func (c *Client) validate(msg *Message) error {
+	maxTokens := c.MaxRegistrationIDs
+	if maxTokens <= 0 {
+		maxTokens = maxSendTokens
+	}
+	errs := msg.fieldErrors(maxTokens)
+	if msg != nil {
+		errs = append(errs, dataErrors(msg.Data, c.StringifyData)...)
+		if c.RejectDataConflicts {
+			errs = append(errs, msg.dataConflictErrors()...)
+		}
+		if c.StrictValidation {
+			errs = append(errs, msg.strictErrors()...)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if c.OnWarning != nil {
+		for _, warning := range msg.warnings() {
+			c.OnWarning(msg, warning)
 		}
-		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", *acsToken))
-		req.Header.Add("Content-Type", "application/json")
+	}
+
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, msg *Message, acsJsonData []byte, o *sendOptions) (*Response, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := c.verifyImages(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	validateOnly := o.validateOnly(msg)
+	response := &Response{Validated: validateOnly}
+	msg = o.prepare(c.withDefaultData(msg))
 
-		resp, err := c.Http.Do(req)
+	start := c.now()
+	acsToken, err := c.accessToken(ctx, o.acsJsonData(acsJsonData))
+	if err != nil {
+		return nil, err
+	}
+	response.TokenLatency = c.now().Sub(start)
+	for _, token := range msg.targets() {
+		wrappedMsg := WrappedMessage{ValidateOnly: validateOnly, Message: newMessageV1(msg, token, c.now())}
+		result, err := c.post(ctx, acsToken, wrappedMsg, o)
 		if err != nil {
+			prunable := (c.PruneUnregistered || o.pruneInvalid) && isPrunable(err)
+			if !prunable && !(o.pruneInvalid && isInvalidTokenArgument(err)) {
+				if o.onTokenError == nil {
+					return nil, err
+				}
+				o.onTokenError(token, err)
+			}
+
+			errResult := newErrorResult(token, err)
+			result = &errResult
+		}
+
+		// 各レスポンスをスライスに追加
+		result.CorrelationID = msg.CorrelationID
+		result.Meta = msg.Meta
+		response.Results = append(response.Results, *result)
+	}
+	response.classify(c.PruneUnregistered || o.pruneInvalid, o.pruneInvalid)
+
+	return response, nil
+}
+
+// post sends a single v1 request to the FCM server and returns the result for
+// the token of wrappedMsg. A non-200 response is returned as an *FCMError.
+// Transient failures are retried up to MaxRetries times; the Latency of the
+// result is the total round-trip time of the attempts, without the waits
+// between them.
+func (c *Client) post(ctx context.Context, acsToken string, wrappedMsg WrappedMessage, o *sendOptions) (*Result, error) {
+	payload := getBuffer()
+	defer payload.release()
+	if err := payload.encode(wrappedMsg); err != nil {
+		return nil, err
+	}
+
+	return c.postBody(ctx, acsToken, wrappedMsg.Message.Token, payload, wrappedMsg.ValidateOnly, o)
+}
+
+// postBody is like post for an encoded request body addressed to token.
+// payload is read until the transports closed the request bodies, see
+// pooledBuffer.
+func (c *Client) postBody(ctx context.Context, acsToken, token string, payload *pooledBuffer, validateOnly bool, o *sendOptions) (*Result, error) {
+	if o.onRequestBody != nil {
+		o.onRequestBody(payload.Bytes())
+	}
+
+	body, compressed, err := c.compress(payload)
+	if err != nil {
+		return nil, err
+	}
+	defer body.release()
+
+	var latency time.Duration
+	for attempt := 1; ; attempt++ {
+		if err := c.breaker.allow(); err != nil {
 			return nil, err
 		}
-		defer resp.Body.Close()
+		result, roundTrip, err := c.postOnce(ctx, acsToken, token, body, compressed, o)
+		c.breaker.record(ctx, err)
+		latency += roundTrip
+		if err == nil {
+			result.Latency = latency
+			result.Attempts = attempt
+			if validateOnly {
+				// A validated message is not delivered; its name is a placeholder.
+				result.MessageID = ""
+			}
+		}
+		if err == nil || attempt > c.MaxRetries || !IsRetryable(err) {
+			return result, err
+		}
 
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("invalid status code %d: %s", resp.StatusCode, resp.Status)
+		timer := time.NewTimer(c.retryDelay(attempt, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
 		}
+	}
+}
 
-		var response Response
-		decoder := json.NewDecoder(resp.Body)
-		if err := decoder.Decode(&response); err != nil {
-			return nil, err
+// postOnce sends a single attempt of a v1 request and returns its result and
+// the time the HTTP round-trip took.
+func (c *Client) postOnce(ctx context.Context, acsToken, token string, body *pooledBuffer, compressed bool, o *sendOptions) (*Result, time.Duration, error) {
+	if err := c.waitRateLimit(ctx, 1); err != nil {
+		return nil, 0, err
+	}
+	if err := c.acquireInFlight(ctx); err != nil {
+		return nil, 0, err
+	}
+	defer c.releaseInFlight()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", o.url(c), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Body = body.newBody()
+	req.GetBody = func() (io.ReadCloser, error) { return body.newBody(), nil }
+	req.ContentLength = int64(body.Len())
+	req.Header.Set("User-Agent", c.userAgent())
+	o.apply(req)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", acsToken))
+	req.Header.Set("Content-Type", "application/json")
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if err := c.sign(req, body.Bytes()); err != nil {
+		return nil, 0, err
+	}
+
+	start := c.now()
+	resp, err := o.http(c).Do(req)
+	if err != nil {
+		return nil, c.now().Sub(start), err
+	}
+	defer resp.Body.Close()
+	resp.Body = c.limitBody(resp.Body)
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	latency := c.now().Sub(start)
+	if o.onResponse != nil {
+		o.onResponse(resp, respBody)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fcmErr := newFCMError(resp.StatusCode, resp.Status, respBody)
+		fcmErr.QuotaHeaders = quotaHeaders(resp.Header)
+		return nil, latency, fcmErr
+	}
+	if err != nil {
+		return nil, latency, err
+	}
+	if !c.IgnoreOKErrorBody {
+		if fcmErr := newOKBodyError(resp, respBody); fcmErr != nil {
+			fcmErr.QuotaHeaders = quotaHeaders(resp.Header)
+			return nil, latency, fcmErr
 		}
+	}
 
-		// 各レスポンスをスライスに追加
-		responses = append(responses, response)
+	var v1Response struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(respBody, &v1Response); err != nil {
+		return nil, latency, newDecodeError(resp, respBody, err)
+	}
+
+	return &Result{Token: token, MessageID: v1Response.Name, QuotaHeaders: quotaHeaders(resp.Header)}, latency, nil
+}
+
+// limitBody wraps the response body body so that reading more than
+// MaxResponseBodySize bytes fails.
+func (c *Client) limitBody(body io.ReadCloser) io.ReadCloser {
+	limit := c.MaxResponseBodySize
+	if limit <= 0 {
+		limit = defaultMaxResponseBodySize
+	}
+	return &limitedBody{ReadCloser: body, limit: limit, remaining: limit}
+}
+
+// limitedBody is a response body failing with ErrResponseTooLarge once more
+// than limit bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, b.limit)
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n - 1, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, b.limit)
 	}
+	return n, err
+}
 
-	return &responses[0], err
+// sign calls SignRequest, if set, with req and its body.
+func (c *Client) sign(req *http.Request, body []byte) error {
+	if c.SignRequest == nil {
+		return nil
+	}
+	if err := c.SignRequest(req, body); err != nil {
+		return fmt.Errorf("failed to sign the request: %w", err)
+	}
+	return nil
 }
 
-func getAcsessToken(acsJsonData []byte) (*string, error) {
-	// // service-account.jsonを取得
-	// data, err := ioutil.ReadFile("./serviceAccountKey.json")
-	// if err != nil {
-	// 	fmt.Printf("failed to read service account file: %v", err)
-	// 	return nil, err
-	// }
+// compress gzips body when it is larger than GzipThreshold. It reports whether
+// the returned body is compressed. The returned body must be released.
+func (c *Client) compress(body *pooledBuffer) (*pooledBuffer, bool, error) {
+	if c.GzipThreshold <= 0 || body.Len() <= c.GzipThreshold {
+		body.retain()
+		return body, false, nil
+	}
+
+	compressed, err := gzipBuffer(body)
+	if err != nil {
+		return nil, false, err
+	}
+	return compressed, true, nil
+}
 
-	// OAuth2トークンを取得するために、Googleのクレデンシャルを使用
-	creds, err := google.CredentialsFromJSON(context.Background(), acsJsonData, "https://www.googleapis.com/auth/firebase.messaging")
+// accessToken returns an OAuth2 access token for the service account
+// acsJsonData. The token source of each service account is cached, so the
+// token is only fetched again when it expires. A transient failure of the
+// token endpoint is retried up to twice. It fails with the error of ctx once
+// ctx is done, also while fetching the token or waiting to retry.
+func (c *Client) accessToken(ctx context.Context, acsJsonData []byte) (string, error) {
+	tokenSource, err := c.tokenSource(acsJsonData)
 	if err != nil {
-		return nil, fmt.Errorf("error getting credentials: %v", err)
+		return "", err
 	}
 
 	// トークンの取得
-	tokenSource := creds.TokenSource
-	token, err := tokenSource.Token()
-	if err != nil {
-		return nil, fmt.Errorf("error getting token: %v", err)
+	for attempt := 1; ; attempt++ {
+		token, err := fetchToken(ctx, tokenSource)
+		if err == nil {
+			return token.AccessToken, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+
+		if attempt > maxTokenRetries || !shouldRetryToken(err) {
+			return "", newTokenError(err)
+		}
+		timer := time.NewTimer(tokenBackoff.Next(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// fetchToken returns the token of tokenSource, or the error of ctx once ctx
+// is done. The fetch of an oauth2.TokenSource can not be canceled, so a
+// fetch given up on finishes in the background, and a cached token source
+// keeps its token for the next send.
+func fetchToken(ctx context.Context, tokenSource oauth2.TokenSource) (*oauth2.Token, error) {
+	if ctx.Done() == nil {
+		return tokenSource.Token()
 	}
 
-	// アクセストークンを表示
-	//fmt.Printf("Access Token: %s\n", token.AccessToken)
+	type fetched struct {
+		token *oauth2.Token
+		err   error
+	}
+	done := make(chan fetched, 1)
+	go func() {
+		token, err := tokenSource.Token()
+		done <- fetched{token, err}
+	}()
 
-	return &token.AccessToken, err
+	select {
+	case f := <-done:
+		return f.token, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// tokenSource returns the cached token source of acsJsonData. The token
+// source outlives the sends it is created for, so it fetches with the
+// background context; accessToken bounds each fetch with the context of the
+// send instead.
+func (c *Client) tokenSource(acsJsonData []byte) (oauth2.TokenSource, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+
+	key := string(acsJsonData)
+	if tokenSource, ok := c.tokenSources[key]; ok {
+		return tokenSource, nil
+	}
+
+	var tokenSource oauth2.TokenSource
+	if len(acsJsonData) == 0 && c.newSource != nil {
+		var err error
+		if tokenSource, err = c.newSource(); err != nil {
+			return nil, err
+		}
+	} else {
+		// OAuth2トークンを取得するために、Googleのクレデンシャルを使用
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, c.Http)
+		creds, err := google.CredentialsFromJSON(ctx, acsJsonData, MessagingScope)
+		if err != nil {
+			return nil, fmt.Errorf("error getting credentials: %v", err)
+		}
+		tokenSource = creds.TokenSource
+	}
+
+	if c.tokenSources == nil {
+		c.tokenSources = make(map[string]oauth2.TokenSource)
+	}
+	c.tokenSources[key] = tokenSource
+
+	return tokenSource, nil
+}
+
+// RefreshToken discards the cached access tokens and immediately fetches a
+// new one for each service account c has sent with, e.g. after a key
+// rotation. The errors of the fetches are joined. Sends in flight keep
+// using the tokens they already got, so it is safe to call concurrently
+// with them. A client of NewClientWithDefaultCredentials finds the default
+// credentials again; a client of NewClientWithTokenSource asks its token
+// source for a token again, which gets a new one only if the token source
+// does not cache its tokens itself.
+func (c *Client) RefreshToken(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClientClosed
+	}
+	keys := make([]string, 0, len(c.tokenSources))
+	for key := range c.tokenSources {
+		keys = append(keys, key)
+	}
+	c.tokenSources = nil
+	c.mu.Unlock()
+
+	var errs []error
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := c.accessToken(ctx, []byte(key)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the idle connections of the HTTP client and discards the cached
+// access tokens. The idle connections are left open if the HTTP client uses
+// http.DefaultTransport, e.g. http.DefaultClient, which is shared by the
+// other HTTP clients of the process. Sending with a closed client returns
+// ErrClientClosed. Close is idempotent and safe to call concurrently with
+// other methods.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.tokenSources = nil
+
+	if c.Http != nil && !usesDefaultTransport(c.Http) {
+		c.Http.CloseIdleConnections()
+	}
+
+	return nil
+}
+
+// usesDefaultTransport reports whether hc sends its requests through the
+// process-wide http.DefaultTransport.
+func usesDefaultTransport(hc *http.Client) bool {
+	return hc.Transport == nil || hc.Transport == http.DefaultTransport
+}
+
+// userAgent returns the User-Agent header of the requests of c.
+func (c *Client) userAgent() string {
+	if c.UserAgent != "" {
+		return c.UserAgent
+	}
+	return defaultUserAgent
 }
 
 func MakeFCMSendEndpoint(projectID string) string {
 	return fmt.Sprintf("https://fcm.googleapis.com/v1/projects/%s/messages:send", projectID)
 }
+
+// endpointProject returns the project ID of an FCM send endpoint like the
+// ones of MakeFCMSendEndpoint, or "" if urlString is not such an endpoint.
+func endpointProject(urlString string) string {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "v1" || parts[1] != "projects" || parts[3] != "messages:send" {
+		return ""
+	}
+	return parts[2]
+}
@@ -1,13 +1,68 @@
 package gcm
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKeyPEM  []byte
 )
 
+// testCredentials returns service account JSON whose token_uri points at
+// tokenURL, so access tokens are minted by a test server instead of Google.
+func testCredentials(t testing.TB, tokenURL string) []byte {
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("failed to generate test key: %s", err)
+		}
+		testKeyPEM = pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})
+	})
+
+	creds, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "test-project",
+		"private_key_id": "test-key-id",
+		"private_key":    string(testKeyPEM),
+		"client_email":   "test@test-project.iam.gserviceaccount.com",
+		"token_uri":      tokenURL,
+	})
+	return creds
+}
+
+// serveTestToken responds to an OAuth2 token request with a fixed access token.
+func serveTestToken(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"access_token":"test-access-token","token_type":"Bearer","expires_in":3600}`)
+}
+
 type testResponse struct {
 	StatusCode int
 	Response   *Response
@@ -16,6 +71,10 @@ type testResponse struct {
 func startTestServer(t *testing.T, resp *testResponse) *httptest.Server {
 	t.Log("startTestServer call.")
 	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
 		status := resp.StatusCode
 		if status == 0 || status == http.StatusOK {
 			w.Header().Set("Content-Type", "application/json")
@@ -93,7 +152,7 @@ func TestSend(t *testing.T) {
 		}
 
 		msg := NewMessage(map[string]interface{}{"key": "value"}, "1")
-		_, err = sender.Send(msg, []byte("dummy"))
+		_, err = sender.Send(msg, testCredentials(t, server.URL+"/token"))
 
 		if err != nil {
 			if tc.success {
@@ -111,3 +170,1460 @@ func TestSend(t *testing.T) {
 		server.Close()
 	}
 }
+
+func TestSendToDeviceGroup(t *testing.T) {
+	server := startTestServer(t, &testResponse{Response: &Response{}})
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	msg := NewMessage(map[string]interface{}{"key": "value"})
+	if _, err := sender.SendToDeviceGroup("", msg, creds); err == nil {
+		t.Fatalf("expect to be failed (missing notification key)")
+	}
+
+	if _, err := sender.SendToDeviceGroup("group-key", msg, creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	if msg.RegistrationIDs != nil {
+		t.Fatalf("expect the original message not to be modified")
+	}
+}
+
+func TestSendGzip(t *testing.T) {
+	var (
+		encoding string
+		received WrappedMessage
+	)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		encoding = r.Header.Get("Content-Encoding")
+		body := io.Reader(r.Body)
+		if encoding == "gzip" {
+			gr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("invalid gzip body: %s", err)
+				return
+			}
+			body = gr
+		}
+		if err := json.NewDecoder(body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	small := NewMessage(map[string]interface{}{"key": "value"}, "1")
+	large := NewMessage(map[string]interface{}{"key": strings.Repeat("x", 2048)}, "1")
+
+	cases := []struct {
+		threshold int
+		msg       *Message
+		encoding  string
+	}{
+		{0, large, ""},
+		{1024, small, ""},
+		{1024, large, "gzip"},
+	}
+
+	for i, tc := range cases {
+		sender.GzipThreshold = tc.threshold
+		if _, err := sender.Send(tc.msg, creds); err != nil {
+			t.Fatalf("#%d expect to be success: %v", i, err)
+		}
+		if encoding != tc.encoding {
+			t.Fatalf("#%d expect Content-Encoding %q, got %q", i, tc.encoding, encoding)
+		}
+		if received.Message.Data["key"] != tc.msg.Data["key"] {
+			t.Fatalf("#%d expect the body to round-trip", i)
+		}
+	}
+}
+
+func TestSendDryRunAll(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		if !received.ValidateOnly {
+			t.Errorf("expect validate_only to be set")
+		}
+
+		switch received.Message.Token {
+		case "unregistered":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`)
+		case "malformed":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"code":400,"status":"INVALID_ARGUMENT","message":"The registration token is not a valid FCM registration token","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"}]}}`)
+		case "too-big":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"code":400,"status":"INVALID_ARGUMENT","message":"Message is too big","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"}]}}`)
+		default:
+			fmt.Fprint(w, `{"name":"projects/test/messages/fake_message_id"}`)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	msg := NewMessage(map[string]interface{}{"key": "value"}, "1", "unregistered", "2", "malformed")
+	valid, invalid, err := sender.SendDryRunAll(msg, testCredentials(t, server.URL+"/token"))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	if strings.Join(valid, ",") != "1,2" {
+		t.Fatalf("expect valid tokens 1,2, got %v", valid)
+	}
+	if strings.Join(invalid, ",") != "unregistered,malformed" {
+		t.Fatalf("expect invalid tokens unregistered,malformed, got %v", invalid)
+	}
+
+	// A message level INVALID_ARGUMENT is not about the token.
+	msg = NewMessage(map[string]interface{}{"key": "value"}, "1", "too-big")
+	if valid, invalid, err := sender.SendDryRunAll(msg, testCredentials(t, server.URL+"/token")); err == nil {
+		t.Fatalf("expect to be failed (message too big), got valid %v and invalid %v", valid, invalid)
+	}
+}
+
+func TestSendResultsByToken(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		fmt.Fprintf(w, `{"name":"projects/test/messages/%s"}`, received.Message.Token)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	msg := NewMessage(map[string]interface{}{"key": "value"}, "a", "b", "c")
+	resp, err := sender.Send(msg, testCredentials(t, server.URL+"/token"))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	if len(resp.Results) != 3 {
+		t.Fatalf("expect 3 results, got %d", len(resp.Results))
+	}
+	ids := resp.MessageIDs()
+	for _, token := range msg.RegistrationIDs {
+		if want := "projects/test/messages/" + token; ids[token] != want {
+			t.Fatalf("expect message ID %q for %s, got %q", want, token, ids[token])
+		}
+	}
+}
+
+func TestAccessTokenCache(t *testing.T) {
+	var tokenRequests int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			serveTestToken(w)
+			return
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	for i := 0; i < 3; i++ {
+		if _, err := sender.Send(NewMessage(nil, "1"), creds); err != nil {
+			t.Fatalf("#%d expect to be success: %v", i, err)
+		}
+	}
+
+	if tokenRequests != 1 {
+		t.Fatalf("expect the access token to be fetched once, got %d", tokenRequests)
+	}
+}
+
+func TestMaxRegistrationIDs(t *testing.T) {
+	sender, err := NewClient("dummy-end-point", "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	msg := NewMessage(nil, "1", "2", "3")
+	if err := sender.validate(msg); err != nil {
+		t.Fatalf("expect validate() to be success: %v", err)
+	}
+
+	sender.MaxRegistrationIDs = 2
+	if err := sender.validate(msg); err == nil {
+		t.Fatalf("expect validate() to be failed (more than MaxRegistrationIDs)")
+	}
+
+	sender.MaxRegistrationIDs = 0
+	if err := sender.validate(NewMessage(nil, make([]string, 1001)...)); err == nil {
+		t.Fatalf("expect validate() to be failed (more than 1000 registration IDs)")
+	}
+}
+
+func TestClose(t *testing.T) {
+	server := startTestServer(t, &testResponse{Response: &Response{}})
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.Http = &http.Client{Transport: &http.Transport{}}
+	creds := testCredentials(t, server.URL+"/token")
+
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sender.Close(); err != nil {
+				t.Errorf("expect Close() to be success: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); err != ErrClientClosed {
+		t.Fatalf("expect ErrClientClosed, got %v", err)
+	}
+}
+
+// idleCloser is a transport recording whether its idle connections were
+// closed.
+type idleCloser struct {
+	http.RoundTripper
+	closed bool
+}
+
+func (t *idleCloser) CloseIdleConnections() {
+	t.closed = true
+}
+
+func TestCloseIdleConnections(t *testing.T) {
+	transport := &idleCloser{RoundTripper: http.DefaultTransport}
+	sender, err := NewClient("http://localhost", "testAPIKey", WithTransport(transport))
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.Close()
+	if !transport.closed {
+		t.Fatalf("expect the idle connections of the transport to be closed")
+	}
+
+	for _, hc := range []*http.Client{http.DefaultClient, {}, {Transport: http.DefaultTransport}} {
+		if !usesDefaultTransport(hc) {
+			t.Fatalf("expect %+v to use the default transport", hc)
+		}
+	}
+	if usesDefaultTransport(&http.Client{Transport: transport}) {
+		t.Fatalf("expect a client with its own transport not to use the default transport")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestWithTransport(t *testing.T) {
+	var sent int
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		if req.URL.Path == "/token" {
+			serveTestToken(rec)
+			return rec.Result(), nil
+		}
+		sent++
+		fmt.Fprint(rec, `{"name":"projects/test/messages/1"}`)
+		return rec.Result(), nil
+	})
+
+	sender, err := NewClient("https://fcm.example.com/v1/projects/test/messages:send", "testAPIKey", WithTransport(rt))
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	resp, err := sender.Send(NewMessage(nil, "1"), testCredentials(t, "https://oauth2.example.com/token"))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if sent != 1 || resp.Results[0].MessageID != "projects/test/messages/1" {
+		t.Fatalf("expect the message to be sent through the transport, got %d sends and %+v", sent, resp.Results)
+	}
+}
+
+func TestSendOne(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		if received.Message.Token == "unregistered" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"name":"projects/test/messages/%s"}`, received.Message.Token)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	resp, err := sender.SendOne("1", NewMessage(nil), creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].MessageID != "projects/test/messages/1" {
+		t.Fatalf("unexpected results: %+v", resp.Results)
+	}
+
+	_, err = sender.SendOne("unregistered", NewMessage(nil), creds)
+	fcmErr, ok := err.(*FCMError)
+	if !ok || fcmErr.ErrorCode != "UNREGISTERED" {
+		t.Fatalf("expect an UNREGISTERED *FCMError, got %v", err)
+	}
+
+	if _, err := sender.SendOne("1", NewMessage(nil, "1", "2"), creds); err == nil {
+		t.Fatalf("expect to be failed (several targets)")
+	}
+	if _, err := sender.SendOne("", NewMessage(nil), creds); err == nil {
+		t.Fatalf("expect to be failed (empty token)")
+	}
+}
+
+func TestErrUnauthorized(t *testing.T) {
+	cases := []struct {
+		path    string
+		status  int
+		body    string
+		matches bool
+	}{
+		{"/token", http.StatusBadRequest, `{"error":"invalid_grant","error_description":"Invalid JWT Signature."}`, true},
+		{"/token", http.StatusInternalServerError, `{}`, false},
+		{"/send", http.StatusUnauthorized, `{"error":{"code":401,"status":"UNAUTHENTICATED"}}`, true},
+		{"/send", http.StatusForbidden, `{"error":{"code":403,"status":"PERMISSION_DENIED"}}`, true},
+		{"/send", http.StatusNotFound, `{"error":{"code":404,"status":"NOT_FOUND"}}`, false},
+	}
+
+	for i, tc := range cases {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != tc.path {
+				serveTestToken(w)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(tc.status)
+			fmt.Fprint(w, tc.body)
+		}
+		server := httptest.NewServer(http.HandlerFunc(handler))
+
+		sender, err := NewClient(server.URL+"/send", "testAPIKey")
+		if err != nil {
+			t.Fatalf("Failed to setup sender client: %s", err)
+		}
+
+		_, err = sender.Send(NewMessage(nil, "1"), testCredentials(t, server.URL+"/token"))
+		server.Close()
+
+		if err == nil {
+			t.Fatalf("#%d expect to be failed", i)
+		}
+		if errors.Is(err, ErrUnauthorized) != tc.matches {
+			t.Fatalf("#%d expect errors.Is(err, ErrUnauthorized) to be %v: %v", i, tc.matches, err)
+		}
+	}
+}
+
+func TestSendDryRun(t *testing.T) {
+	var validateOnly bool
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		validateOnly = string(received["validate_only"]) == "true"
+		if strings.Contains(string(received["message"]), "dry_run") {
+			t.Errorf("expect dry_run not to be sent in the message")
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/fake_message_id"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	msg := NewMessage(nil, "1")
+	msg.DryRun = true
+	resp, err := sender.Send(msg, testCredentials(t, server.URL+"/token"))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	if !validateOnly {
+		t.Fatalf("expect validate_only to be sent")
+	}
+	if !resp.Validated || resp.Results[0].MessageID != "" {
+		t.Fatalf("expect a validated response without message ID, got %+v", resp)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var userAgent string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		userAgent = r.Header.Get("User-Agent")
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if userAgent != "gaurun-gcm/"+Version {
+		t.Fatalf("expect the default User-Agent, got %q", userAgent)
+	}
+
+	sender.UserAgent = "myapp/1.0"
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if userAgent != "myapp/1.0" {
+		t.Fatalf("expect the overridden User-Agent, got %q", userAgent)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	cases := []struct {
+		status  int
+		body    string
+		success bool
+	}{
+		{http.StatusBadRequest, `{"error":{"code":400,"status":"INVALID_ARGUMENT","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"}]}}`, true},
+		{http.StatusOK, `{"name":"projects/test/messages/fake"}`, true},
+		{http.StatusUnauthorized, `{"error":{"code":401,"status":"UNAUTHENTICATED"}}`, false},
+	}
+
+	for i, tc := range cases {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/token" {
+				serveTestToken(w)
+				return
+			}
+
+			var received WrappedMessage
+			if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+				t.Errorf("invalid request body: %s", err)
+				return
+			}
+			if !received.ValidateOnly {
+				t.Errorf("expect the health check to be validate_only")
+			}
+			w.WriteHeader(tc.status)
+			fmt.Fprint(w, tc.body)
+		}
+		server := httptest.NewServer(http.HandlerFunc(handler))
+
+		sender, err := NewClient(server.URL, "testAPIKey")
+		if err != nil {
+			t.Fatalf("Failed to setup sender client: %s", err)
+		}
+
+		err = sender.HealthCheck(context.Background(), testCredentials(t, server.URL+"/token"))
+		server.Close()
+
+		if (err == nil) != tc.success {
+			t.Fatalf("#%d expect success to be %v, got %v", i, tc.success, err)
+		}
+	}
+}
+
+func TestRejectDataConflicts(t *testing.T) {
+	sender, err := NewClient("http://localhost", "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	msg := NewMessage(map[string]interface{}{"from": "me"}, "1")
+	if err := sender.validate(msg); err != nil {
+		t.Fatalf("expect reserved keys to be accepted by default: %v", err)
+	}
+
+	sender.RejectDataConflicts = true
+	var validationErr *ValidationError
+	if err := sender.validate(msg); !errors.As(err, &validationErr) || validationErr.Field != `Data["from"]` {
+		t.Fatalf("expect the reserved key to be rejected, got %v", err)
+	}
+}
+
+func TestStrictValidation(t *testing.T) {
+	sender, err := NewClient("http://localhost", "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	cases := []struct {
+		msg   *Message
+		field string
+	}{
+		{&Message{RegistrationIDs: []string{"1"}, Kind: KindNotification, Notification: Notification{Body: "hello"}, Data: map[string]interface{}{"k": "v"}}, "Data"},
+		{&Message{RegistrationIDs: []string{"1"}, Kind: KindData, Notification: Notification{Body: "hello"}}, "Notification"},
+		{&Message{RegistrationIDs: []string{"1"}, DelayWhileIdle: true}, "DelayWhileIdle"},
+		{&Message{RegistrationIDs: []string{"1"}, Priority: "high", Android: &Android{Priority: "normal"}}, "Priority"},
+		{&Message{RegistrationIDs: []string{"1"}, TimeToLive: 60, Android: &Android{TTL: "120s"}}, "TimeToLive"},
+		{&Message{RegistrationIDs: []string{"1"}, Android: &Android{TTL: "1h"}}, "Android.TTL"},
+		{&Message{RegistrationIDs: []string{"1"}, APNS: &APNS{Payload: &APNSPayload{Aps: Aps{ContentAvailable: 2}}}}, "APNS.Payload.Aps.ContentAvailable"},
+	}
+
+	for i, tc := range cases {
+		sender.StrictValidation = false
+		if err := sender.validate(tc.msg); err != nil {
+			t.Fatalf("#%d expect the message to be accepted by default: %v", i, err)
+		}
+
+		sender.StrictValidation = true
+		var validationErr *ValidationError
+		if err := sender.validate(tc.msg); !errors.As(err, &validationErr) || validationErr.Field != tc.field {
+			t.Fatalf("#%d expect %s to be rejected, got %v", i, tc.field, err)
+		}
+	}
+
+	msg := &Message{RegistrationIDs: []string{"1"}, Priority: "high", TimeToLive: 60, Android: &Android{Priority: "high", TTL: "60s"}}
+	if err := sender.validate(msg); err != nil {
+		t.Fatalf("expect matching Android values to be accepted: %v", err)
+	}
+}
+
+func TestFCMErrorBody(t *testing.T) {
+	body := `{"error":{"code":400,"message":"The registration token is not a valid FCM registration token","status":"INVALID_ARGUMENT"}}`
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, body)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	_, err = sender.Send(NewMessage(nil, "1"), testCredentials(t, server.URL+"/token"))
+	if err == nil {
+		t.Fatalf("expect to be failed")
+	}
+	if !strings.Contains(err.Error(), "The registration token is not a valid FCM registration token") {
+		t.Fatalf("expect the response body in the error, got %q", err)
+	}
+
+	long := &FCMError{StatusCode: http.StatusBadRequest, Body: []byte(strings.Repeat("x", 1000)), httpStatus: "400 Bad Request"}
+	if got := len(long.Error()); got > maxErrorBodyLength+100 {
+		t.Fatalf("expect the body in the error to be truncated, got %d bytes", got)
+	}
+}
+
+func TestPruneUnregistered(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		if received.Message.Token == "unregistered" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"name":"projects/test/messages/%s"}`, received.Message.Token)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+	msg := NewMessage(nil, "1", "unregistered", "2")
+
+	if _, err := sender.Send(msg, creds); err == nil {
+		t.Fatalf("expect to be failed without PruneUnregistered")
+	}
+
+	sender.PruneUnregistered = true
+	resp, err := sender.Send(msg, creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if len(resp.Results) != 3 || resp.Results[1].Error != "UNREGISTERED" || resp.Results[2].MessageID != "projects/test/messages/2" {
+		t.Fatalf("unexpected results: %+v", resp.Results)
+	}
+	if resp.FailureCount != 0 || len(resp.InvalidTokens) != 1 || resp.InvalidTokens[0] != "unregistered" {
+		t.Fatalf("expect the unregistered token to be pruned, got %d failures and %v", resp.FailureCount, resp.InvalidTokens)
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "queue-entry-1") {
+			t.Errorf("expect the correlation ID not to be sent, got %s", body)
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	msg := NewMessage(nil, "a", "b")
+	msg.CorrelationID = "queue-entry-1"
+	resp, err := sender.Send(msg, testCredentials(t, server.URL+"/token"))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	for i, result := range resp.Results {
+		if result.CorrelationID != "queue-entry-1" {
+			t.Fatalf("#%d expect the correlation ID in the result, got %+v", i, result)
+		}
+	}
+}
+
+func TestFCMErrorFieldViolations(t *testing.T) {
+	body := []byte(`{
+  "error": {
+    "code": 400,
+    "message": "Invalid value at 'message.android.ttl' (type.googleapis.com/google.protobuf.Duration), Field 'ttl', Illegal duration format; duration must end with 's'",
+    "status": "INVALID_ARGUMENT",
+    "details": [
+      {
+        "@type": "type.googleapis.com/google.rpc.BadRequest",
+        "fieldViolations": [
+          {
+            "field": "message.android.ttl",
+            "description": "Invalid value at 'message.android.ttl' (type.googleapis.com/google.protobuf.Duration), Field 'ttl', Illegal duration format; duration must end with 's'"
+          }
+        ]
+      }
+    ]
+  }
+}`)
+
+	fcmErr := newFCMError(http.StatusBadRequest, "400 Bad Request", body)
+	if fcmErr.Status != "INVALID_ARGUMENT" {
+		t.Fatalf("expect INVALID_ARGUMENT, got %q", fcmErr.Status)
+	}
+	if len(fcmErr.FieldViolations) != 1 || fcmErr.FieldViolations[0].Field != "message.android.ttl" {
+		t.Fatalf("expect the field violation of message.android.ttl, got %+v", fcmErr.FieldViolations)
+	}
+	if fcmErr.FieldViolations[0].Description == "" {
+		t.Fatalf("expect the description of the field violation")
+	}
+}
+
+func TestSendNonJSONResponse(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>Proxy maintenance</body></html>")
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	_, err = sender.Send(NewMessage(nil, "1"), testCredentials(t, server.URL+"/token"))
+	if err == nil {
+		t.Fatalf("expect to be failed")
+	}
+	if !strings.Contains(err.Error(), "text/html") || !strings.Contains(err.Error(), "Proxy maintenance") {
+		t.Fatalf("expect the content type and the body in the error, got %q", err)
+	}
+}
+
+func TestSendOKErrorBody(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	_, err = sender.Send(NewMessage(nil, "1"), creds)
+	var fcmErr *FCMError
+	if !errors.As(err, &fcmErr) {
+		t.Fatalf("expect the error body to fail the send, got %v", err)
+	}
+	if fcmErr.StatusCode != http.StatusNotFound || fcmErr.ErrorCode != "UNREGISTERED" {
+		t.Fatalf("expect the error of the body, got %+v", fcmErr)
+	}
+
+	sender.IgnoreOKErrorBody = true
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); err != nil {
+		t.Fatalf("expect the 200 response to be a success, got %v", err)
+	}
+}
+
+func TestNormalizeTokens(t *testing.T) {
+	var received []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var wrapped WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&wrapped); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		received = append(received, wrapped.Message.Token)
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	msg := NewMessage(nil, " a", "b\n", "", "  ")
+	_, err = sender.Send(msg, creds)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || !strings.Contains(validationErr.Reason, "[2 3]") {
+		t.Fatalf("expect the indices of the empty tokens in the error, got %v", err)
+	}
+	if len(received) != 0 {
+		t.Fatalf("expect nothing to be sent")
+	}
+
+	sender.DropEmptyTokens = true
+	resp, err := sender.Send(msg, creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if fmt.Sprint(received) != "[a b]" || len(resp.Results) != 2 {
+		t.Fatalf("expect the trimmed non-empty tokens to be sent, got %q", received)
+	}
+	if msg.RegistrationIDs[0] != " a" {
+		t.Fatalf("expect the original message not to be modified")
+	}
+}
+
+func TestSendSingle(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		if received.Message.Token == "unregistered" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`)
+			return
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	result := sender.SendSingle("1", NewMessage(nil), creds)
+	if err := result.Err(); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if result.MessageID != "projects/test/messages/1" {
+		t.Fatalf("unexpected message ID: %q", result.MessageID)
+	}
+
+	err = sender.SendSingle("unregistered", NewMessage(nil), creds).Err()
+	var failed *SendResult
+	if !errors.As(err, &failed) {
+		t.Fatalf("expect a SendResult error, got %v", err)
+	}
+	if failed.Token != "unregistered" || failed.StatusCode != http.StatusNotFound || failed.ErrorCode != "UNREGISTERED" {
+		t.Fatalf("unexpected failed result: %+v", failed)
+	}
+	var fcmErr *FCMError
+	if !errors.As(err, &fcmErr) {
+		t.Fatalf("expect the FCMError to be unwrapped")
+	}
+
+	sender.PruneUnregistered = true
+	if err := sender.SendSingle("unregistered", NewMessage(nil), creds).Err(); !errors.As(err, &failed) || failed.ErrorCode != "UNREGISTERED" {
+		t.Fatalf("expect a pruned token to be a failed single send, got %v", err)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	var fetches int
+	var mu sync.Mutex
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			mu.Lock()
+			fetches++
+			mu.Unlock()
+			serveTestToken(w)
+			return
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	if err := sender.RefreshToken(context.Background()); err != nil || fetches != 0 {
+		t.Fatalf("expect nothing to refresh before sending, got %v with %d fetches", err, fetches)
+	}
+
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := sender.RefreshToken(context.Background()); err != nil {
+				t.Errorf("expect RefreshToken() to be success: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := sender.Send(NewMessage(nil, "1"), creds); err != nil {
+				t.Errorf("expect to be success: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches < 2 {
+		t.Fatalf("expect the token to be fetched again, got %d fetches", fetches)
+	}
+
+	sender.Close()
+	if err := sender.RefreshToken(context.Background()); err != ErrClientClosed {
+		t.Fatalf("expect ErrClientClosed, got %v", err)
+	}
+}
+
+func TestSendRaw(t *testing.T) {
+	raw := `{"token":"1","android":{"notification":{"title":"hello","new_field":true}}}`
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if got, want := strings.TrimSpace(string(body)), `{"message":`+raw+`}`; got != want {
+			t.Errorf("expect the raw message to be sent as is, got %s", got)
+			return
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	resp, err := sender.SendRaw(context.Background(), json.RawMessage(raw), creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].Token != "1" || resp.Results[0].MessageID != "projects/test/messages/1" {
+		t.Fatalf("unexpected results: %+v", resp.Results)
+	}
+
+	for _, invalid := range []string{``, `{}`, `{"token":`, `["1"]`} {
+		var validationErr *ValidationError
+		if _, err := sender.SendRaw(context.Background(), json.RawMessage(invalid), creds); !errors.As(err, &validationErr) {
+			t.Fatalf("expect %q to be rejected, got %v", invalid, err)
+		}
+	}
+}
+
+func TestSendToTopic(t *testing.T) {
+	var requests int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		requests++
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		if received.Message.Topic != "news" || received.Message.Token != "" {
+			t.Errorf("expect the message to target the topic, got %+v", received.Message)
+			return
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	resp, err := sender.Send(&Message{Topic: "news"}, creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if requests != 1 || len(resp.Results) != 1 || resp.Results[0].MessageID != "projects/test/messages/1" {
+		t.Fatalf("expect a single request to the topic, got %d requests and %+v", requests, resp.Results)
+	}
+
+	if _, err := sender.SendOne("1", &Message{Topic: "news"}, creds); err == nil {
+		t.Fatalf("expect a token and a topic to conflict")
+	}
+}
+
+func TestSendToTopics(t *testing.T) {
+	var condition string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		condition = received.Message.Condition
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	cases := []struct {
+		topics   []string
+		matchAll bool
+		want     string
+	}{
+		{[]string{"news"}, true, "'news' in topics"},
+		{[]string{"news", "/topics/sports"}, true, "'news' in topics && 'sports' in topics"},
+		{[]string{"a", "b", "c"}, false, "'a' in topics || 'b' in topics || 'c' in topics"},
+	}
+	for i, tc := range cases {
+		if _, err := sender.SendToTopics(tc.topics, tc.matchAll, NewMessage(nil, "1"), creds); err != nil {
+			t.Fatalf("#%d expect to be success: %v", i, err)
+		}
+		if condition != tc.want {
+			t.Fatalf("#%d expect the condition %q, got %q", i, tc.want, condition)
+		}
+	}
+
+	invalid := [][]string{nil, {"a", "b", "c", "d", "e", "f"}, {"news' in topics || 'x"}}
+	for i, topics := range invalid {
+		var validationErr *ValidationError
+		if _, err := sender.SendToTopics(topics, false, NewMessage(nil), creds); !errors.As(err, &validationErr) {
+			t.Fatalf("#%d expect a ValidationError, got %v", i, err)
+		}
+	}
+}
+
+func TestMaxResponseBodySize(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		fmt.Fprintf(w, `{"name":"projects/test/messages/1","padding":"%s"}`, strings.Repeat("x", 1024))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); err != nil {
+		t.Fatalf("expect the default limit to accept the response: %v", err)
+	}
+
+	sender.MaxResponseBodySize = 512
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("expect ErrResponseTooLarge, got %v", err)
+	}
+}
+
+func TestSendSameMessageConcurrently(t *testing.T) {
+	server := startTestServer(t, &testResponse{Response: &Response{}})
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	msg, err := NewMessageBuilder().
+		AddToken(" 1 ").AddToken("2").
+		WithNotification("title", "body").
+		WithData(map[string]interface{}{"key": "value"}).
+		WithLocalizedBody("body", "BODY_KEY", "arg").
+		WithPriority("normal").
+		Build()
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	msg.SetAPNSPushType("alert")
+	msg.SetTimeToLive(0)
+	want := msg.Clone()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := sender.Send(msg, creds, WithAutoCollapse(true)); err != nil {
+				errs <- err
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if _, err := sender.SendToAll([]string{fmt.Sprint(i)}, msg, creds); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	if !reflect.DeepEqual(msg, want) {
+		t.Fatalf("expect the message to be unmodified, got %+v", msg)
+	}
+}
+
+func TestDefaultData(t *testing.T) {
+	var received WrappedMessage
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		received = WrappedMessage{}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.DefaultData = map[string]string{"app_version": "1.2.3", "env": "production"}
+	creds := testCredentials(t, server.URL+"/token")
+
+	data := map[string]interface{}{"env": "staging", "key": "value"}
+	if _, err := sender.Send(NewMessage(data, "1"), creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	want := map[string]string{"app_version": "1.2.3", "env": "staging", "key": "value"}
+	if !reflect.DeepEqual(received.Message.Data, want) {
+		t.Fatalf("expect the data %v, got %v", want, received.Message.Data)
+	}
+	if len(data) != 2 {
+		t.Fatalf("expect the data of the message to be unmodified, got %v", data)
+	}
+
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if received.Message.Data["app_version"] != "1.2.3" {
+		t.Fatalf("expect the default data, got %v", received.Message.Data)
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	key := []byte("secret")
+	sign := func(body []byte) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	var sends int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		sends++
+		body, _ := ioutil.ReadAll(r.Body)
+		if got := r.Header.Get("X-Signature"); got != sign(body) {
+			t.Errorf("expect the signature of the body, got %q", got)
+			return
+		}
+		if r.Header.Get("Authorization") == "" {
+			t.Errorf("expect the Authorization header")
+			return
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+	sender.SignRequest = func(req *http.Request, body []byte) error {
+		req.Header.Set("X-Signature", sign(body))
+		return nil
+	}
+
+	large := NewMessage(map[string]interface{}{"key": strings.Repeat("x", 2048)}, "1")
+	for _, threshold := range []int{0, 1024} {
+		sender.GzipThreshold = threshold
+		if _, err := sender.Send(large, creds); err != nil {
+			t.Fatalf("expect to be success with GzipThreshold %d: %v", threshold, err)
+		}
+	}
+
+	errSign := errors.New("no signing key")
+	sender.SignRequest = func(req *http.Request, body []byte) error {
+		return errSign
+	}
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); !errors.Is(err, errSign) {
+		t.Fatalf("expect the signing error, got %v", err)
+	}
+	if sends != 2 {
+		t.Fatalf("expect no request when signing fails, got %d sends", sends)
+	}
+}
+
+func TestSendEach(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		switch received.Message.Token {
+		case "gone":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`)
+		case "broken":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"code":400,"status":"INVALID_ARGUMENT"}}`)
+		default:
+			fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	err = sender.SendEach(NewMessage(nil, "gone", "1", "broken"), creds)
+	var tokenErr *TokenError
+	if !errors.As(err, &tokenErr) || tokenErr.Token != "gone" {
+		t.Fatalf("expect the error of the first failed token, got %v", err)
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "token gone: ") || !strings.HasPrefix(lines[1], "token broken: ") {
+		t.Fatalf("expect a line per failed token, got %q", err.Error())
+	}
+	var fcmErr *FCMError
+	if !errors.As(err, &fcmErr) || fcmErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expect the FCMError of the token, got %v", err)
+	}
+
+	sender.PruneUnregistered = true
+	if err := sender.SendEach(NewMessage(nil, "gone", "1"), creds); err != nil {
+		t.Fatalf("expect the pruned token not to fail, got %v", err)
+	}
+	if err := sender.SendEach(NewMessage(nil), creds); err == nil {
+		t.Fatalf("expect an invalid message to fail")
+	}
+}
+
+type countingTokenSource struct {
+	fetches int
+}
+
+func (s *countingTokenSource) Token() (*oauth2.Token, error) {
+	s.fetches++
+	return &oauth2.Token{AccessToken: "workload-token", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func TestNewClientWithTokenSource(t *testing.T) {
+	if _, err := NewClientWithTokenSource("http://localhost", nil); err == nil {
+		t.Fatalf("expect to be failed (missing token source)")
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer workload-token" {
+			t.Errorf("expect the token of the token source, got %q", got)
+			return
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	ts := &countingTokenSource{}
+	sender, err := NewClientWithTokenSource(server.URL, ts)
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := sender.Send(NewMessage(nil, "1"), nil); err != nil {
+			t.Fatalf("#%d expect to be success: %v", i, err)
+		}
+	}
+	if ts.fetches != 1 {
+		t.Fatalf("expect the token to be cached, got %d fetches", ts.fetches)
+	}
+
+	if err := sender.RefreshToken(context.Background()); err != nil || ts.fetches != 2 {
+		t.Fatalf("expect the token to be fetched again, got %d fetches and %v", ts.fetches, err)
+	}
+}
+
+func TestNewClientWithDefaultCredentials(t *testing.T) {
+	server := startTestServer(t, &testResponse{Response: &Response{}})
+	defer server.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "key.json")
+	if err := ioutil.WriteFile(keyFile, testCredentials(t, server.URL+"/token"), 0600); err != nil {
+		t.Fatalf("failed to write the key file: %v", err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", keyFile)
+
+	sender, err := NewClientWithDefaultCredentials(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	if _, err := sender.Send(NewMessage(nil, "1"), nil); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	if _, err := NewClientWithDefaultCredentials(MakeFCMSendEndpoint("test-project")); err != nil {
+		t.Fatalf("expect the project of the endpoint to match: %v", err)
+	}
+	if _, err := NewClientWithDefaultCredentials(MakeFCMSendEndpoint("other-project")); err == nil {
+		t.Fatalf("expect to be failed (project mismatch)")
+	}
+}
+
+func TestSendWithTimeout(t *testing.T) {
+	release := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-token" {
+			<-release
+		}
+		if strings.HasSuffix(r.URL.Path, "token") {
+			serveTestToken(w)
+			return
+		}
+		if r.Header.Get("X-Slow") != "" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	defer close(release)
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	if _, err := sender.SendWithTimeout(time.Second, NewMessage(nil, "1"), creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	slow := WithHeaders(map[string]string{"X-Slow": "1"})
+	if _, err := sender.SendWithTimeout(50*time.Millisecond, NewMessage(nil, "1"), creds, slow); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect context.DeadlineExceeded, got %v", err)
+	}
+
+	// The timeout also bounds fetching the access token.
+	start := time.Now()
+	if _, err := sender.SendWithTimeout(50*time.Millisecond, NewMessage(nil, "1"), testCredentials(t, server.URL+"/slow-token")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expect the send to give up on the token after the timeout, took %s", elapsed)
+	}
+}
@@ -0,0 +1,28 @@
+package gcm
+
+import "time"
+
+// Clock tells the current time. The client computes APNs expirations, send
+// delays, Retry-After delays, latencies and circuit breaker cooldowns from
+// it, so tests can replace the wall clock with a fake one. A Clock does not
+// wait: the waits between retries, including those of the access token
+// fetch, and for a scheduled send use real timers.
+type Clock interface {
+	Now() time.Time
+}
+
+// WithClock makes the client read the current time from clock instead of
+// the wall clock.
+func WithClock(clock Clock) ClientOption {
+	return func(c *Client) {
+		c.clock = clock
+	}
+}
+
+// now returns the current time of the clock of c.
+func (c *Client) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
@@ -0,0 +1,70 @@
+package gcm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1600000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestWithClock(t *testing.T) {
+	var expiration string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		expiration = received.Message.APNS.Headers[apnsExpirationHeader]
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	clock := newFakeClock()
+	sender, err := NewClient(server.URL, "testAPIKey", WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	msg := NewMessage(nil, "1")
+	msg.SetTimeToLive(60)
+	msg.SetAPNSExpirationFromTTL()
+	if _, err := sender.Send(msg, testCredentials(t, server.URL+"/token")); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if want := strconv.FormatInt(clock.Now().Unix()+60, 10); expiration != want {
+		t.Fatalf("expect apns-expiration %s from the clock, got %q", want, expiration)
+	}
+}
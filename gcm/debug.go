@@ -0,0 +1,83 @@
+package gcm
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// testNotificationTitle and testNotificationBody are the notification
+	// of the message SendTest sends.
+	testNotificationTitle = "Test Notification"
+	testNotificationBody  = "This is a test notification sent to check the credentials and the registration token."
+)
+
+// DebugResult is the verbose outcome of Client.SendTest.
+type DebugResult struct {
+	// Token is the registration token the test message was sent to, without
+	// the surrounding whitespace.
+	Token string
+	// RequestBody is the JSON body of the request, as Client.Marshal
+	// returns it.
+	RequestBody []byte
+	// StatusCode, Status and ResponseBody are those of the response, those
+	// of the last attempt if the send was retried. They are zero if no
+	// response was received, e.g. because the access token could not be
+	// fetched.
+	StatusCode   int
+	Status       string
+	ResponseBody []byte
+	// MessageID is the message name FCM assigned when the send succeeded.
+	MessageID string
+
+	// TokenLatency is the time fetching the access token took, Latency the
+	// time the HTTP round-trips took and Elapsed the time the whole send
+	// took. Attempts is the number of requests sent.
+	TokenLatency time.Duration
+	Latency      time.Duration
+	Elapsed      time.Duration
+	Attempts     int
+}
+
+// SendTest sends a test notification titled "Test Notification" to token
+// and returns the request and the response in detail, to check that the
+// credentials and the token work. The message is sent like with Send, with
+// the same access token, retries and options, and is delivered unless it is
+// a dry run, see WithDryRun. An empty token fails with a ValidationError
+// before anything is sent. The DebugResult is returned even when the send
+// fails, along with the error.
+func (c *Client) SendTest(token string, acsJsonData []byte, opts ...SendOption) (*DebugResult, error) {
+	token = strings.TrimSpace(token)
+	msg := NewMessage(nil, token)
+	msg.Notification = Notification{Title: testNotificationTitle, Body: testNotificationBody}
+
+	debug := &DebugResult{Token: token}
+	if err := c.validate(msg); err != nil {
+		return debug, err
+	}
+	opts = append(opts, func(o *sendOptions) {
+		o.onRequestBody = func(body []byte) {
+			debug.RequestBody = append([]byte(nil), body...)
+		}
+		o.onResponse = func(resp *http.Response, body []byte) {
+			debug.StatusCode, debug.Status = resp.StatusCode, resp.Status
+			debug.ResponseBody = append([]byte(nil), body...)
+		}
+	})
+
+	start := c.now()
+	resp, err := c.send(context.Background(), msg, acsJsonData, newSendOptions(opts))
+	debug.Elapsed = c.now().Sub(start)
+	if resp != nil {
+		debug.TokenLatency = resp.TokenLatency
+		if len(resp.Results) == 1 {
+			result := resp.Results[0]
+			debug.MessageID = result.MessageID
+			debug.Latency = result.Latency
+			debug.Attempts = result.Attempts
+		}
+	}
+	return debug, err
+}
@@ -0,0 +1,75 @@
+package gcm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendTest(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		if received.Message.Token == "unregistered" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`))
+			return
+		}
+		w.Write([]byte(`{"name":"projects/test/messages/1"}`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	debug, err := sender.SendTest(" 1 ", creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if debug.Token != "1" || debug.StatusCode != http.StatusOK || debug.MessageID != "projects/test/messages/1" || debug.Attempts != 1 {
+		t.Fatalf("unexpected debug result: %+v", debug)
+	}
+	if !strings.Contains(string(debug.RequestBody), `"title":"Test Notification"`) || !strings.Contains(string(debug.RequestBody), `"token":"1"`) {
+		t.Fatalf("expect the request body of the test notification, got %s", debug.RequestBody)
+	}
+	if string(debug.ResponseBody) != `{"name":"projects/test/messages/1"}` {
+		t.Fatalf("expect the response body, got %s", debug.ResponseBody)
+	}
+	if debug.Elapsed < debug.Latency {
+		t.Fatalf("expect the send to take longer than its round-trips, got %s and %s", debug.Elapsed, debug.Latency)
+	}
+
+	debug, err = sender.SendTest("unregistered", creds)
+	if err == nil {
+		t.Fatalf("expect to be failed (unregistered token)")
+	}
+	if debug.StatusCode != http.StatusNotFound || !strings.Contains(string(debug.ResponseBody), "UNREGISTERED") {
+		t.Fatalf("expect the error response, got %+v", debug)
+	}
+
+	for _, token := range []string{"", "  "} {
+		debug, err = sender.SendTest(token, creds)
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("expect a validation error for token %q, got %v", token, err)
+		}
+		if debug.StatusCode != 0 || debug.RequestBody != nil {
+			t.Fatalf("expect nothing to be sent for token %q, got %+v", token, debug)
+		}
+	}
+}
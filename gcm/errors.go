@@ -0,0 +1,367 @@
+package gcm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// fcmErrorDetailType is the type of the FCM specific entry of an error's details.
+	fcmErrorDetailType = "type.googleapis.com/google.firebase.fcm.v1.FcmError"
+
+	// badRequestDetailType is the type of the entry of an error's details
+	// listing the invalid fields of the request.
+	badRequestDetailType = "type.googleapis.com/google.rpc.BadRequest"
+
+	// fcmErrorCodeUnregistered and fcmErrorCodeInvalidArgument are the FCM
+	// error codes of a registration token that can not receive messages.
+	// See more on https://firebase.google.com/docs/reference/fcm/rest/v1/ErrorCode
+	fcmErrorCodeUnregistered    = "UNREGISTERED"
+	fcmErrorCodeInvalidArgument = "INVALID_ARGUMENT"
+
+	// fcmErrorCodeSenderIDMismatch is the FCM error code of a registration
+	// token of another Firebase project.
+	fcmErrorCodeSenderIDMismatch = "SENDER_ID_MISMATCH"
+)
+
+// maxErrorBodyLength is the max number of bytes of the response body in the
+// message of an FCMError.
+const maxErrorBodyLength = 512
+
+// ErrClientClosed is returned when a Client is used after Close.
+var ErrClientClosed = errors.New("the client is closed")
+
+// ErrCircuitOpen is returned instead of sending while the circuit breaker
+// of the client is open, see WithCircuitBreaker.
+var ErrCircuitOpen = errors.New("the circuit breaker is open: FCM is failing, retry later")
+
+// ErrResponseTooLarge is returned when a response body is larger than
+// Client.MaxResponseBodySize.
+var ErrResponseTooLarge = errors.New("the response body is too large")
+
+// ErrUnauthorized is matched by errors.Is when the credentials were rejected,
+// either while fetching the access token (e.g. a revoked key or a skewed
+// clock) or by the FCM server with 401 Unauthorized or 403 Forbidden.
+// Retrying does not help; the credentials have to be refreshed or fixed.
+var ErrUnauthorized = errors.New("unauthorized: the credentials are invalid, expired or revoked")
+
+// ErrSenderIDMismatch is matched by errors.Is when FCM rejected a
+// registration token of another Firebase project. The token never works with
+// this project, so it is pruned like an unregistered one, but it usually
+// means that the credentials or the token store are misconfigured, e.g.
+// after a migration, and is worth an alert.
+var ErrSenderIDMismatch = errors.New("the registration token belongs to another sender")
+
+// ErrPartialFailure is matched by errors.Is when a multicast send, e.g.
+// Client.SendToAll, delivered the message to some of the tokens but failed
+// for the others. The error is a *PartialFailureError holding the Response.
+var ErrPartialFailure = errors.New("the message failed for some of the tokens")
+
+// ErrAllFailed is matched by errors.Is when a multicast send failed for all
+// of the tokens.
+var ErrAllFailed = errors.New("the message failed for all of the tokens")
+
+// PartialFailureError is returned by a multicast send that succeeded for
+// some of the tokens only, see ErrPartialFailure. The failed tokens are the
+// Results of Response with an Error.
+type PartialFailureError struct {
+	Response *Response
+	// Err is the cause of the failures that are not FCM errors of single
+	// tokens, e.g. a failed request of a chunk, or nil.
+	Err error
+}
+
+func (e *PartialFailureError) Error() string {
+	msg := fmt.Sprintf("the message failed for %d of %d tokens", e.Response.FailureCount, len(e.Response.Results))
+	if e.Err == nil {
+		return msg
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+// Is reports whether the error matches target, i.e. ErrPartialFailure.
+func (e *PartialFailureError) Is(target error) bool {
+	return target == ErrPartialFailure
+}
+
+func (e *PartialFailureError) Unwrap() error {
+	return e.Err
+}
+
+// TokenError is the failure of a single registration token of a send, see
+// Client.SendEach.
+type TokenError struct {
+	Token string
+	Err   error
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("token %s: %v", e.Token, e.Err)
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+// FCMError is returned when the FCM server responds with a status other than
+// "200 OK". The fields other than StatusCode are parsed from the error body
+// and are empty when the body is not an FCM error.
+type FCMError struct {
+	// StatusCode is the HTTP status code of the response, or the code of
+	// the error object of a 200 response, see Client.IgnoreOKErrorBody.
+	StatusCode int
+	// Status is the canonical error status, e.g. "NOT_FOUND".
+	Status string
+	// ErrorCode is the FCM specific error code, e.g. "UNREGISTERED".
+	ErrorCode string
+	// Message is the human readable description of the error.
+	Message string
+	// FieldViolations are the invalid fields of the request FCM reports
+	// with INVALID_ARGUMENT.
+	FieldViolations []FieldViolation
+	// Body is the raw response body.
+	Body []byte
+	// QuotaHeaders are the rate limit and quota headers of the response,
+	// see Result.QuotaHeaders.
+	QuotaHeaders map[string]string
+
+	httpStatus string
+}
+
+// FieldViolation is an invalid field of a request, e.g. the Field
+// "message.android.ttl" with a Description of the problem.
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+func (e *FCMError) Error() string {
+	if len(e.Body) == 0 {
+		return fmt.Sprintf("invalid status code %d: %s", e.StatusCode, e.httpStatus)
+	}
+
+	return fmt.Sprintf("invalid status code %d: %s: %s", e.StatusCode, e.httpStatus, truncateBody(e.Body))
+}
+
+// truncateBody returns body shortened to maxErrorBodyLength bytes for an
+// error message.
+func truncateBody(body []byte) []byte {
+	if len(body) > maxErrorBodyLength {
+		return append(body[:maxErrorBodyLength:maxErrorBodyLength], "..."...)
+	}
+	return body
+}
+
+// newDecodeError describes a successful response whose body could not be
+// decoded, e.g. an HTML page of a proxy, with the beginning of the body.
+func newDecodeError(resp *http.Response, body []byte, err error) error {
+	return fmt.Errorf("unexpected response body (Content-Type %q): %w: %s",
+		resp.Header.Get("Content-Type"), err, truncateBody(body))
+}
+
+// newOKBodyError returns the error of a 200 response whose body holds an
+// error object, as a proxy or a batch sub-response may wrap a failure in, or
+// nil if the body holds none. The StatusCode of the error is the code of the
+// error object if any, so that it is classified like the failure it wraps.
+func newOKBodyError(resp *http.Response, body []byte) *FCMError {
+	var v struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &v); err != nil || len(v.Error) == 0 || string(v.Error) == "null" {
+		return nil
+	}
+
+	var object struct {
+		Code int `json:"code"`
+	}
+	if err := json.Unmarshal(v.Error, &object); err != nil {
+		// A plain string error holds the description only.
+		fcmErr := newFCMError(resp.StatusCode, resp.Status, body)
+		json.Unmarshal(v.Error, &fcmErr.Message)
+		return fcmErr
+	}
+	if object.Code <= http.StatusOK {
+		return newFCMError(resp.StatusCode, resp.Status, body)
+	}
+	return newFCMError(object.Code, fmt.Sprintf("%d %s", object.Code, http.StatusText(object.Code)), body)
+}
+
+// Is reports whether the error matches target. A 401 or 403 response matches
+// ErrUnauthorized, except for a SENDER_ID_MISMATCH one, which matches
+// ErrSenderIDMismatch.
+func (e *FCMError) Is(target error) bool {
+	if e.ErrorCode == fcmErrorCodeSenderIDMismatch {
+		return target == ErrSenderIDMismatch
+	}
+	return target == ErrUnauthorized &&
+		(e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden)
+}
+
+// ValidationError is returned when a message is rejected before sending.
+// Field is the name of the Message field at fault, e.g. "TimeToLive", and
+// Reason describes the problem. When several fields are invalid the errors
+// are joined, so use errors.As or an Unwrap() []error type assertion to get
+// each of them.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// unauthorizedError is a token fetch error caused by rejected credentials.
+type unauthorizedError struct {
+	err error
+}
+
+func (e *unauthorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unauthorizedError) Unwrap() error {
+	return e.err
+}
+
+func (e *unauthorizedError) Is(target error) bool {
+	return target == ErrUnauthorized
+}
+
+// newTokenError wraps an error of fetching an access token, marking the
+// rejections of the credentials as ErrUnauthorized.
+func newTokenError(err error) error {
+	tokenErr := fmt.Errorf("error getting token: %w", err)
+
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return tokenErr
+	}
+
+	errorCode := retrieveErr.ErrorCode
+	if errorCode == "" {
+		// The JWT flow of service accounts leaves the error body unparsed.
+		var errBody struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(retrieveErr.Body, &errBody) == nil {
+			errorCode = errBody.Error
+		}
+	}
+
+	switch errorCode {
+	case "invalid_grant", "invalid_client", "unauthorized_client":
+		return &unauthorizedError{tokenErr}
+	}
+	if retrieveErr.Response != nil &&
+		(retrieveErr.Response.StatusCode == http.StatusUnauthorized || retrieveErr.Response.StatusCode == http.StatusForbidden) {
+		return &unauthorizedError{tokenErr}
+	}
+
+	return tokenErr
+}
+
+// newFCMError parses an error response of the FCM server.
+func newFCMError(statusCode int, httpStatus string, body []byte) *FCMError {
+	fcmErr := &FCMError{StatusCode: statusCode, Body: body, httpStatus: httpStatus}
+
+	var errBody struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+			Details []struct {
+				Type            string           `json:"@type"`
+				ErrorCode       string           `json:"errorCode"`
+				FieldViolations []FieldViolation `json:"fieldViolations"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errBody); err != nil {
+		return fcmErr
+	}
+
+	fcmErr.Status = errBody.Error.Status
+	fcmErr.Message = errBody.Error.Message
+	for _, detail := range errBody.Error.Details {
+		switch detail.Type {
+		case fcmErrorDetailType:
+			fcmErr.ErrorCode = detail.ErrorCode
+		case badRequestDetailType:
+			fcmErr.FieldViolations = append(fcmErr.FieldViolations, detail.FieldViolations...)
+		}
+	}
+
+	return fcmErr
+}
+
+// code returns the most specific error code of e, the FCM error code if any
+// or else the canonical status.
+func (e *FCMError) code() string {
+	if e.ErrorCode != "" {
+		return e.ErrorCode
+	}
+	return e.Status
+}
+
+// isUnregistered reports whether err means the registration token is no
+// longer registered, an UNREGISTERED or NOT_FOUND error of FCM.
+func isUnregistered(err error) bool {
+	var fcmErr *FCMError
+	return errors.As(err, &fcmErr) && isUnregisteredCode(fcmErr.code())
+}
+
+// isUnregisteredCode reports whether the error code of a Result means the
+// registration token is no longer registered.
+func isUnregisteredCode(code string) bool {
+	return code == fcmErrorCodeUnregistered || code == "NOT_FOUND"
+}
+
+// isPrunable reports whether err means the registration token will never
+// receive messages of this project: it is unregistered or belongs to another
+// project.
+func isPrunable(err error) bool {
+	var fcmErr *FCMError
+	return errors.As(err, &fcmErr) && isPrunableCode(fcmErr.code())
+}
+
+// isPrunableCode is like isPrunable for the error code of a Result.
+func isPrunableCode(code string) bool {
+	return isUnregisteredCode(code) || code == fcmErrorCodeSenderIDMismatch
+}
+
+// isInvalidTokenArgument reports whether err is an INVALID_ARGUMENT error
+// caused by the registration token rather than by the rest of the message.
+func isInvalidTokenArgument(err error) bool {
+	var fcmErr *FCMError
+	if !errors.As(err, &fcmErr) || fcmErr.ErrorCode != fcmErrorCodeInvalidArgument {
+		return false
+	}
+
+	for _, violation := range fcmErr.FieldViolations {
+		if violation.Field == "message.token" {
+			return true
+		}
+	}
+	return strings.Contains(fcmErr.Message, "registration token")
+}
+
+// isInvalidToken reports whether err means the registration token the
+// message was sent to can not receive messages.
+func isInvalidToken(err error) bool {
+	var fcmErr *FCMError
+	if !errors.As(err, &fcmErr) {
+		return false
+	}
+
+	switch fcmErr.ErrorCode {
+	case fcmErrorCodeUnregistered, fcmErrorCodeInvalidArgument, fcmErrorCodeSenderIDMismatch:
+		return true
+	}
+
+	return fcmErr.ErrorCode == "" && fcmErr.Status == "NOT_FOUND"
+}
@@ -0,0 +1,170 @@
+package gcm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	// IIDEndpoint is the endpoint of the Instance ID API managing the topic
+	// subscriptions of registration tokens.
+	// See more on https://developers.google.com/instance-id/reference/server
+	IIDEndpoint = "https://iid.googleapis.com"
+)
+
+// topicNamePattern is the format of a topic name, without the "/topics/"
+// prefix.
+var topicNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.~%-]+$`)
+
+// TokenInfo is the metadata of a registration token returned by the
+// Instance ID API.
+type TokenInfo struct {
+	Application      string       `json:"application"`
+	AuthorizedEntity string       `json:"authorizedEntity"`
+	Platform         string       `json:"platform"`
+	AppSigner        string       `json:"appSigner,omitempty"`
+	Rel              TokenInfoRel `json:"rel"`
+}
+
+// TokenInfoRel holds the relations of a registration token. Topics are the
+// topics the token is subscribed to keyed by topic name.
+type TokenInfoRel struct {
+	Topics map[string]TokenInfoTopic `json:"topics"`
+}
+
+type TokenInfoTopic struct {
+	AddDate string `json:"addDate"`
+}
+
+// GetTokenInfo returns the app, platform and topic subscriptions of a
+// registration token.
+func (c *Client) GetTokenInfo(token string, acsJsonData []byte) (*TokenInfo, error) {
+	if len(token) == 0 {
+		return nil, fmt.Errorf("the registration token must not be empty")
+	}
+
+	acsToken, err := c.accessToken(context.Background(), acsJsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", c.IIDURL+"/iid/info/"+url.PathEscape(token)+"?details=true", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", acsToken))
+	req.Header.Add("access_token_auth", "true")
+	req.Header.Set("User-Agent", c.userAgent())
+
+	resp, err := c.Http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	resp.Body = c.limitBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, newFCMError(resp.StatusCode, resp.Status, errBody)
+	}
+
+	var info TokenInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// SubscribeToTopic subscribes registration tokens to a topic. The topic may
+// be given with or without the "/topics/" prefix. At most 1000 tokens can be
+// subscribed at once. The returned Response holds one Result per token whose
+// Error is the reason reported by the Instance ID API, e.g. "NOT_FOUND", when
+// the token could not be subscribed.
+func (c *Client) SubscribeToTopic(topic string, tokens []string, acsJsonData []byte) (*Response, error) {
+	return c.manageTopic("/iid/v1:batchAdd", topic, tokens, acsJsonData)
+}
+
+// UnsubscribeFromTopic unsubscribes registration tokens from a topic. It
+// accepts the same topic and tokens as SubscribeToTopic and reports the
+// Instance ID API error of each token that could not be unsubscribed in its
+// Result.
+func (c *Client) UnsubscribeFromTopic(topic string, tokens []string, acsJsonData []byte) (*Response, error) {
+	return c.manageTopic("/iid/v1:batchRemove", topic, tokens, acsJsonData)
+}
+
+func (c *Client) manageTopic(path, topic string, tokens []string, acsJsonData []byte) (*Response, error) {
+	topic = strings.TrimPrefix(topic, "/topics/")
+	if !topicNamePattern.MatchString(topic) {
+		return nil, fmt.Errorf("invalid topic name %q", topic)
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("at least one registration token must be specified")
+	}
+
+	if len(tokens) > maxTopicManagementTokens {
+		return nil, fmt.Errorf("at most %d registration tokens may be specified", maxTopicManagementTokens)
+	}
+
+	acsToken, err := c.accessToken(context.Background(), acsJsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"to":                  "/topics/" + topic,
+		"registration_tokens": tokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", c.IIDURL+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", acsToken))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("access_token_auth", "true")
+	req.Header.Set("User-Agent", c.userAgent())
+
+	resp, err := c.Http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	resp.Body = c.limitBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, newFCMError(resp.StatusCode, resp.Status, errBody)
+	}
+
+	var iidResponse struct {
+		Results []struct {
+			Error string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&iidResponse); err != nil {
+		return nil, err
+	}
+
+	if len(iidResponse.Results) != len(tokens) {
+		return nil, fmt.Errorf("expected %d results from the Instance ID API, got %d", len(tokens), len(iidResponse.Results))
+	}
+
+	response := &Response{}
+	for i, result := range iidResponse.Results {
+		response.Results = append(response.Results, Result{Token: tokens[i], Error: result.Error})
+	}
+
+	return response, nil
+}
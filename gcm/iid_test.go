@@ -0,0 +1,142 @@
+package gcm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// startTestIIDServer returns a server answering Instance ID batch requests.
+// The token "invalid" is reported as NOT_FOUND, all others succeed. The path
+// and topic of the last request are written to path and topic.
+func startTestIIDServer(t *testing.T, path, topic *string) *httptest.Server {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received struct {
+			To     string   `json:"to"`
+			Tokens []string `json:"registration_tokens"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		*path = r.URL.Path
+		*topic = received.To
+
+		var results []map[string]string
+		for _, token := range received.Tokens {
+			if token == "invalid" {
+				results = append(results, map[string]string{"error": "NOT_FOUND"})
+				continue
+			}
+			results = append(results, map[string]string{})
+		}
+		respBytes, _ := json.Marshal(map[string]interface{}{"results": results})
+		fmt.Fprint(w, string(respBytes))
+	}
+	return httptest.NewServer(http.HandlerFunc(handler))
+}
+
+func TestSubscribeToTopic(t *testing.T) {
+	var path, topic string
+	server := startTestIIDServer(t, &path, &topic)
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.IIDURL = server.URL
+	creds := testCredentials(t, server.URL+"/token")
+
+	resp, err := sender.SubscribeToTopic("/topics/news", []string{"1", "invalid"}, creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if path != "/iid/v1:batchAdd" || topic != "/topics/news" {
+		t.Fatalf("unexpected request to %s for %s", path, topic)
+	}
+	if len(resp.Results) != 2 || resp.Results[0].Error != "" || resp.Results[1].Error != "NOT_FOUND" {
+		t.Fatalf("unexpected results: %+v", resp.Results)
+	}
+
+	if _, err := sender.SubscribeToTopic("not a topic", []string{"1"}, creds); err == nil {
+		t.Fatalf("expect to be failed (invalid topic name)")
+	}
+	if _, err := sender.SubscribeToTopic("news", nil, creds); err == nil {
+		t.Fatalf("expect to be failed (no tokens)")
+	}
+	if _, err := sender.SubscribeToTopic("news", make([]string, 1001), creds); err == nil {
+		t.Fatalf("expect to be failed (too many tokens)")
+	}
+}
+
+func TestUnsubscribeFromTopic(t *testing.T) {
+	var path, topic string
+	server := startTestIIDServer(t, &path, &topic)
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.IIDURL = server.URL
+
+	resp, err := sender.UnsubscribeFromTopic("news", []string{"invalid", "1"}, testCredentials(t, server.URL+"/token"))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if path != "/iid/v1:batchRemove" || topic != "/topics/news" {
+		t.Fatalf("unexpected request to %s for %s", path, topic)
+	}
+	if len(resp.Results) != 2 || resp.Results[0].Error != "NOT_FOUND" || resp.Results[1].Error != "" {
+		t.Fatalf("unexpected results: %+v", resp.Results)
+	}
+}
+
+func TestGetTokenInfo(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		if r.URL.Path != "/iid/info/valid" || r.URL.Query().Get("details") != "true" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"application":"com.example.app","authorizedEntity":"123456","platform":"ANDROID","rel":{"topics":{"news":{"addDate":"2024-05-01"}}}}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.IIDURL = server.URL
+	creds := testCredentials(t, server.URL+"/token")
+
+	info, err := sender.GetTokenInfo("valid", creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if info.Application != "com.example.app" || info.Platform != "ANDROID" || info.AuthorizedEntity != "123456" {
+		t.Fatalf("unexpected token info: %+v", info)
+	}
+	if topic, ok := info.Rel.Topics["news"]; !ok || topic.AddDate != "2024-05-01" {
+		t.Fatalf("expect subscription to news, got %+v", info.Rel.Topics)
+	}
+
+	if _, err := sender.GetTokenInfo("unknown", creds); err == nil {
+		t.Fatalf("expect to be failed (unknown token)")
+	}
+	if _, err := sender.GetTokenInfo("", creds); err == nil {
+		t.Fatalf("expect to be failed (empty token)")
+	}
+}
@@ -0,0 +1,70 @@
+package gcm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// imageCheckTimeout is how long VerifyImageURL waits for the response to
+// the HEAD request of an image.
+const imageCheckTimeout = 3 * time.Second
+
+// imageURL is an image URL of a message and the name of its field.
+type imageURL struct {
+	field string
+	url   string
+}
+
+// images returns the image URLs set on m.
+func (m *Message) images() []imageURL {
+	var images []imageURL
+	if m.Notification.Image != "" {
+		images = append(images, imageURL{"Notification.Image", m.Notification.Image})
+	}
+	if m.APNS != nil && m.APNS.FCMOptions != nil && m.APNS.FCMOptions.Image != "" {
+		images = append(images, imageURL{"APNS.FCMOptions.Image", m.APNS.FCMOptions.Image})
+	}
+	return images
+}
+
+// verifyImages checks with a HEAD request that each image URL of msg
+// responds with an image, if VerifyImageURL is set.
+func (c *Client) verifyImages(ctx context.Context, msg *Message) error {
+	if !c.VerifyImageURL {
+		return nil
+	}
+
+	for _, image := range msg.images() {
+		if err := c.verifyImage(ctx, image.url); err != nil {
+			return &ValidationError{Field: image.field, Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+func (c *Client) verifyImage(ctx context.Context, imageURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, imageCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", imageURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", c.userAgent())
+	resp, err := c.Http.Do(req)
+	if err != nil {
+		return fmt.Errorf("the image %q is unreachable: %v", imageURL, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the image %q responds with %s", imageURL, resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "image/") {
+		return fmt.Errorf("the image %q is not an image but %q", imageURL, contentType)
+	}
+	return nil
+}
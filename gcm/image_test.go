@@ -0,0 +1,78 @@
+package gcm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVerifyImageURL(t *testing.T) {
+	var sends int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			serveTestToken(w)
+		case "/image.png":
+			if r.Method != "HEAD" {
+				t.Errorf("expect a HEAD request of the image, got %s", r.Method)
+			}
+			w.Header().Set("Content-Type", "image/png")
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+		case "/missing.png":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			sends++
+			var received WrappedMessage
+			if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+				t.Errorf("invalid request body: %s", err)
+				return
+			}
+			if received.Message.Notification == nil || received.Message.Notification.Image == "" {
+				t.Errorf("expect the notification image, got %+v", received.Message.Notification)
+				return
+			}
+			fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+		}
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL+"/send", "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.Http = server.Client()
+	sender.VerifyImageURL = true
+	creds := testCredentials(t, server.URL+"/token")
+
+	msg := NewMessage(nil, "1")
+	msg.Notification.Image = server.URL + "/image.png"
+	if _, err := sender.Send(msg, creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	for _, path := range []string{"/page.html", "/missing.png"} {
+		msg.Notification.Image = server.URL + path
+		var validationErr *ValidationError
+		if _, err := sender.Send(msg, creds); !errors.As(err, &validationErr) || validationErr.Field != "Notification.Image" {
+			t.Fatalf("expect the image %s to be rejected, got %v", path, err)
+		}
+	}
+	if sends != 1 {
+		t.Fatalf("expect no send with a broken image, got %d sends", sends)
+	}
+
+	sender.VerifyImageURL = false
+	if _, err := sender.Send(msg, creds); err != nil {
+		t.Fatalf("expect the image not to be checked: %v", err)
+	}
+
+	msg.Notification.Image = "http://example.com/image.png"
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (not https)")
+	}
+}
@@ -0,0 +1,42 @@
+package gcm
+
+import "context"
+
+// WithMaxInFlight limits the client to n HTTP requests to FCM in flight at
+// once, shared by all the sends of the client, e.g. the concurrent Send
+// calls of different request handlers, so that together they do not
+// overwhelm the connections to FCM. A request waits for a free slot, or
+// fails once the context of the send is done, before it is issued, and
+// holds its slot until its response is read; a batch request takes a single
+// slot. Without this option or with n <= 0 the requests are unlimited.
+func WithMaxInFlight(n int) ClientOption {
+	return func(c *Client) {
+		if n <= 0 {
+			c.inFlight = nil
+			return
+		}
+		c.inFlight = make(chan struct{}, n)
+	}
+}
+
+// acquireInFlight blocks until a request may be issued or ctx is done. A
+// successful call must be followed by a call to releaseInFlight.
+func (c *Client) acquireInFlight(ctx context.Context) error {
+	if c.inFlight == nil {
+		return nil
+	}
+
+	select {
+	case c.inFlight <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseInFlight frees the slot of a request issued after acquireInFlight.
+func (c *Client) releaseInFlight() {
+	if c.inFlight != nil {
+		<-c.inFlight
+	}
+}
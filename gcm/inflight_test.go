@@ -0,0 +1,77 @@
+package gcm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithMaxInFlight(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	release := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Write([]byte(`{"name":"projects/test/messages/1"}`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey", WithMaxInFlight(2))
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := sender.Send(NewMessage(nil, "1"), creds)
+			errs <- err
+		}()
+	}
+
+	// Two requests hold the slots, so a send waiting for one gives up with
+	// its context.
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := sender.SendContext(ctx, NewMessage(nil, "1"), creds); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect the send to wait for a slot until its deadline, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("expect to be success: %v", err)
+		}
+	}
+	if maxInFlight != 2 {
+		t.Fatalf("expect at most 2 requests in flight, got %d", maxInFlight)
+	}
+}
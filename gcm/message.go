@@ -1,61 +1,421 @@
 package gcm
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// WrappedMessage is the request body of the FCM HTTP v1 send endpoint.
+// When ValidateOnly is true FCM validates the message without delivering it.
 type WrappedMessage struct {
-	Message MessageV1 `json:"message"`
+	ValidateOnly bool      `json:"validate_only,omitempty"`
+	Message      MessageV1 `json:"message"`
 }
 
 type MessageV1 struct {
-	Token                 string                 `json:"token"`
-	CollapseKey           string                 `json:"collapse_key,omitempty"`
-	Notification          NotificationV1         `json:"notification"`
-	Data                  map[string]interface{} `json:"data,omitempty"`
-	DelayWhileIdle        bool                   `json:"delay_while_idle,omitempty"`
-	TimeToLive            int                    `json:"time_to_live,omitempty"`
-	Android               Android                `json:"android,omitempty"`
-	RestrictedPackageName string                 `json:"restricted_package_name,omitempty"`
-	DryRun                bool                   `json:"dry_run,omitempty"`
+	Token          string            `json:"token,omitempty"`
+	Topic          string            `json:"topic,omitempty"`
+	Condition      string            `json:"condition,omitempty"`
+	Notification   *NotificationV1   `json:"notification,omitempty"`
+	Data           map[string]string `json:"data,omitempty"`
+	DelayWhileIdle bool              `json:"delay_while_idle,omitempty"`
+	Android        Android           `json:"android,omitempty"`
+	APNS           *APNS             `json:"apns,omitempty"`
+	Webpush        *Webpush          `json:"webpush,omitempty"`
+	FCMOptions     *FCMOptions       `json:"fcm_options,omitempty"`
+}
+
+// FCMOptions are the platform independent options of features provided by
+// the FCM SDKs.
+type FCMOptions struct {
+	// AnalyticsLabel is the label of the message in the FCM message
+	// delivery data, e.g. in BigQuery exports.
+	AnalyticsLabel string `json:"analytics_label,omitempty"`
 }
 
 type NotificationV1 struct {
 	Title string `json:"title"`
 	Body  string `json:"body"`
+	Image string `json:"image,omitempty"`
 }
 
 type Android struct {
-	Notification AndroidNotification `json:"notification"`
-	Priority     string              `json:"priority,omitempty"`
+	CollapseKey           string               `json:"collapse_key,omitempty"`
+	Notification          *AndroidNotification `json:"notification,omitempty"`
+	Priority              Priority             `json:"priority,omitempty"`
+	TTL                   string               `json:"ttl,omitempty"`
+	RestrictedPackageName string               `json:"restricted_package_name,omitempty"`
+	DirectBootOk          bool                 `json:"direct_boot_ok,omitempty"`
+}
+
+// MarshalJSON sends the Priority in the upper case the HTTP v1 API
+// AndroidMessagePriority enum is spelled in, e.g. "HIGH".
+func (a Android) MarshalJSON() ([]byte, error) {
+	type android Android
+	return json.Marshal(struct {
+		android
+		Priority string `json:"priority,omitempty"`
+	}{android(a), strings.ToUpper(string(a.Priority))})
 }
 
 type AndroidNotification struct {
-	ClickAction string `json:"click_action"`
-	Tag         string `json:"tag"`
+	Title       string `json:"title,omitempty"`
+	Body        string `json:"body,omitempty"`
+	ClickAction string `json:"click_action,omitempty"`
+	Tag         string `json:"tag,omitempty"`
+	Sound       string `json:"sound,omitempty"`
+	// DefaultSound plays the default sound of the Android framework, unless
+	// Sound names another one, which wins: DefaultSound is not sent with a
+	// Sound. See SetDefaultSound.
+	DefaultSound bool `json:"default_sound,omitempty"`
+
+	// TitleLocKey and BodyLocKey are the keys of string resources of the app
+	// localizing the title and body, formatted with the LocArgs. A device
+	// without the resource displays Title and Body, see SetLocalizedTitle.
+	TitleLocKey  string   `json:"title_loc_key,omitempty"`
+	TitleLocArgs []string `json:"title_loc_args,omitempty"`
+	BodyLocKey   string   `json:"body_loc_key,omitempty"`
+	BodyLocArgs  []string `json:"body_loc_args,omitempty"`
+
+	// VibrateTimings is the vibration pattern as durations like "0.5s"
+	// alternating between off and on, see SetVibrateTimings.
+	VibrateTimings        []string       `json:"vibrate_timings,omitempty"`
+	DefaultVibrateTimings bool           `json:"default_vibrate_timings,omitempty"`
+	LightSettings         *LightSettings `json:"light_settings,omitempty"`
+	DefaultLightSettings  bool           `json:"default_light_settings,omitempty"`
+
+	// NotificationCount is the number shown on the app icon by launchers
+	// supporting it. A nil count is not sent; see SetNotificationCount.
+	NotificationCount *int `json:"notification_count,omitempty"`
+
+	// EventTime is when the event the notification is about happened or
+	// happens, shown and used for sorting instead of the delivery time. It
+	// is sent as an RFC 3339 timestamp in UTC unless zero.
+	EventTime time.Time `json:"event_time"`
+	// Sticky keeps the notification when the user taps it.
+	Sticky bool `json:"sticky,omitempty"`
+
+	// Visibility is how much of the notification is shown on a secure lock
+	// screen, one of the Visibility constants; see SetVisibility.
+	Visibility string `json:"visibility,omitempty"`
+
+	// Ticker is the text accessibility services, e.g. screen readers,
+	// announce when the notification arrives, see SetTicker. SubText is the
+	// additional text shown below the body, see SetSubText.
+	Ticker  string `json:"ticker,omitempty"`
+	SubText string `json:"sub_text,omitempty"`
+}
+
+func (n AndroidNotification) MarshalJSON() ([]byte, error) {
+	type notification AndroidNotification
+	v := struct {
+		notification
+		EventTime string `json:"event_time,omitempty"`
+	}{notification: notification(n)}
+	if n.Sound != "" {
+		v.DefaultSound = false
+	}
+	if !n.EventTime.IsZero() {
+		v.EventTime = n.EventTime.UTC().Format(time.RFC3339Nano)
+	}
+	return json.Marshal(v)
+}
+
+// LightSettings controls the notification LED of Android devices. The
+// durations are formatted like "0.5s", see SetLightSettings.
+type LightSettings struct {
+	Color            Color  `json:"color"`
+	LightOnDuration  string `json:"light_on_duration"`
+	LightOffDuration string `json:"light_off_duration"`
+}
+
+// Color is an RGBA color whose components are between 0 and 1.
+type Color struct {
+	Red   float64 `json:"red"`
+	Green float64 `json:"green"`
+	Blue  float64 `json:"blue"`
+	Alpha float64 `json:"alpha,omitempty"`
+}
+
+// APNS is the Apple Push Notification service specific options of a message.
+// See more on https://firebase.google.com/docs/reference/fcm/rest/v1/projects.messages#apnsconfig
+//
+// RawPayload is a JSON object merged into the payload, for the APNs features
+// not modeled by APNSPayload, e.g. {"aps":{"interruption-level":"critical"}}.
+// Its keys take precedence, and the keys of its "aps" object are merged
+// with the modeled "aps" dictionary.
+type APNS struct {
+	Headers    map[string]string `json:"headers,omitempty"`
+	Payload    *APNSPayload      `json:"payload,omitempty"`
+	FCMOptions *APNSFCMOptions   `json:"fcm_options,omitempty"`
+	RawPayload json.RawMessage   `json:"-"`
+}
+
+// APNSFCMOptions are the options of features provided by the FCM SDK for iOS.
+type APNSFCMOptions struct {
+	// Image is the https URL of an image the FCM SDK downloads and attaches
+	// to the notification, see SetAPNSImage.
+	Image string `json:"image,omitempty"`
+}
+
+// MarshalJSON encodes the APNs options with RawPayload merged into the
+// payload.
+func (a APNS) MarshalJSON() ([]byte, error) {
+	type apns APNS
+	if len(a.RawPayload) == 0 {
+		return json.Marshal(apns(a))
+	}
+
+	payload := map[string]interface{}{}
+	if a.Payload != nil {
+		b, err := json.Marshal(a.Payload)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &payload); err != nil {
+			return nil, err
+		}
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(a.RawPayload, &raw); err != nil {
+		return nil, fmt.Errorf("invalid APNs raw payload: %v", err)
+	}
+	for k, v := range raw {
+		rawAps, ok := v.(map[string]interface{})
+		aps, _ := payload[k].(map[string]interface{})
+		if k != "aps" || !ok || aps == nil {
+			payload[k] = v
+			continue
+		}
+		for apsKey, apsValue := range rawAps {
+			aps[apsKey] = apsValue
+		}
+	}
+
+	return json.Marshal(struct {
+		Headers    map[string]string      `json:"headers,omitempty"`
+		Payload    map[string]interface{} `json:"payload"`
+		FCMOptions *APNSFCMOptions        `json:"fcm_options,omitempty"`
+	}{a.Headers, payload, a.FCMOptions})
+}
+
+type APNSPayload struct {
+	Aps Aps `json:"aps"`
+}
+
+// Aps is the "aps" dictionary of an APNs payload.
+// See more on https://developer.apple.com/documentation/usernotifications/setting_up_a_remote_notification_server/generating_a_remote_notification
+type Aps struct {
+	Alert            *ApsAlert `json:"alert,omitempty"`
+	ContentAvailable int       `json:"content-available,omitempty"`
+	MutableContent   int       `json:"mutable-content,omitempty"`
+	Sound            string    `json:"sound,omitempty"`
+	Badge            *int      `json:"badge,omitempty"`
+
+	// ThreadID groups the notifications with the same thread-id in the
+	// notification center, see SetAPNSThreadID.
+	ThreadID string `json:"thread-id,omitempty"`
+	// Category is the identifier of the UNNotificationCategory registered by
+	// the app whose actions are shown with the notification, see
+	// SetAPNSCategory.
+	Category string `json:"category,omitempty"`
+}
+
+type ApsAlert struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+
+	// TitleLocKey and LocKey are the keys of Localizable.strings localizing
+	// the title and body, formatted with the LocArgs.
+	TitleLocKey  string   `json:"title-loc-key,omitempty"`
+	TitleLocArgs []string `json:"title-loc-args,omitempty"`
+	LocKey       string   `json:"loc-key,omitempty"`
+	LocArgs      []string `json:"loc-args,omitempty"`
+}
+
+// Webpush is the Web Push protocol specific options of a message.
+// See more on https://firebase.google.com/docs/reference/fcm/rest/v1/projects.messages#webpushconfig
+type Webpush struct {
+	Headers      map[string]string    `json:"headers,omitempty"`
+	Notification *WebpushNotification `json:"notification,omitempty"`
+	FCMOptions   *WebpushFCMOptions   `json:"fcm_options,omitempty"`
+}
+
+// WebpushFCMOptions are the options of features provided by the FCM SDK for
+// the web.
+type WebpushFCMOptions struct {
+	// AnalyticsLabel labels web push deliveries independently of the
+	// Message.AnalyticsLabel of the other platforms.
+	AnalyticsLabel string `json:"analytics_label,omitempty"`
+}
+
+type WebpushNotification struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
 }
 
 // Message is used by the application server to send a message to
 // the FCM server. See the documentation for FCM Architectural
 // Overview for more information:
 // https://firebase.google.com/docs/cloud-messaging/http-server-ref
+//
+// The FCM v1 API delivers a message to exactly one token per request, so a
+// Message with several RegistrationIDs is fanned out into one request per
+// registration ID. The number of registration IDs is limited to 1000 by
+// default, see Client.MaxRegistrationIDs.
+//
+// Sending does not modify a Message, so the same *Message can be sent
+// concurrently, e.g. to many batches of tokens, as long as it is not
+// modified during the sends. Use Clone to derive a message to modify.
 type Message struct {
-	RegistrationIDs       []string               `json:"registration_ids"`
-	CollapseKey           string                 `json:"collapse_key,omitempty"`
-	Notification          Notification           `json:"notification"`
-	Data                  map[string]interface{} `json:"data,omitempty"`
-	DelayWhileIdle        bool                   `json:"delay_while_idle,omitempty"`
-	TimeToLive            int                    `json:"time_to_live,omitempty"`
-	Priority              string                 `json:"priority,omitempty"`
-	RestrictedPackageName string                 `json:"restricted_package_name,omitempty"`
-	DryRun                bool                   `json:"dry_run,omitempty"`
+	RegistrationIDs []string               `json:"registration_ids"`
+	CollapseKey     string                 `json:"collapse_key,omitempty"`
+	Notification    Notification           `json:"notification"`
+	Data            map[string]interface{} `json:"data,omitempty"`
+	DelayWhileIdle  bool                   `json:"delay_while_idle,omitempty"`
+	TimeToLive      int                    `json:"time_to_live,omitempty"`
+
+	// Priority is sent as the Android priority and as the apns-priority
+	// header: 10 for high and 5 for normal, unless the header is given in
+	// APNS.Headers or the message is content-available, which is always 5.
+	Priority Priority `json:"priority,omitempty"`
+
+	RestrictedPackageName string `json:"restricted_package_name,omitempty"`
+
+	// DryRun is sent as the request level validate_only flag: FCM validates
+	// the message without delivering it and Response.Validated is set.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Topic and Condition target the message at the devices subscribed to
+	// a topic, e.g. "news", or to the topics matching a condition, e.g.
+	// "'news' in topics && 'sports' in topics", in place of RegistrationIDs.
+	// Exactly one of RegistrationIDs, Topic and Condition must be set; a
+	// topic or condition message is sent as a single request.
+	Topic     string `json:"topic,omitempty"`
+	Condition string `json:"condition,omitempty"`
+
+	// AnalyticsLabel labels the message in the FCM delivery data, see
+	// SetWebpushAnalyticsLabel for a separate label of web push.
+	AnalyticsLabel string `json:"analytics_label,omitempty"`
+
+	// Android, APNS and Webpush are the platform specific options of the
+	// message. Their notification title and body override Notification on
+	// the respective platform, so that each platform can show different text
+	// from a single message. Values set in Android take precedence over the
+	// Android related fields above.
+	Android *Android `json:"android,omitempty"`
+	APNS    *APNS    `json:"apns,omitempty"`
+	Webpush *Webpush `json:"webpush,omitempty"`
+
+	// Kind decides which of the top-level notification and data objects are
+	// sent, see Kind.
+	Kind Kind `json:"-"`
+
+	// CorrelationID is an identifier of the caller, e.g. of a queue entry,
+	// copied to each Result of the message. It is not sent to FCM.
+	CorrelationID string `json:"-"`
+	// Meta is any value of the caller, e.g. the record the message was made
+	// from, copied to each Result of the message like CorrelationID. It is
+	// not sent to FCM.
+	Meta interface{} `json:"-"`
+
+	// timeToLiveSet reports whether TimeToLive was set by SetTimeToLive, which
+	// makes a zero TimeToLive meaningful.
+	timeToLiveSet bool
+
+	// apnsCollapseFromKey and apnsExpirationFromTTL make the apns-collapse-id
+	// and apns-expiration headers follow CollapseKey and TimeToLive.
+	apnsCollapseFromKey   bool
+	apnsExpirationFromTTL bool
+
+	// requireNotification makes validate reject a message without a
+	// notification title and body, see MessageBuilder.RequireNotification.
+	requireNotification bool
+
+	// apnsCategorySet reports whether the APNs category was set by
+	// SetAPNSCategory, which makes validate reject an empty category.
+	apnsCategorySet bool
 }
 
+// Kind is the FCM message type of a Message.
+// See more on https://firebase.google.com/docs/cloud-messaging/concept-options#notifications_and_data_messages
+type Kind int
+
+const (
+	// KindDefault sends the notification object, empty if no title and body
+	// are set, and the data object if Data is not empty.
+	KindDefault Kind = iota
+	// KindNotification sends only the notification object, which must have
+	// a title or a body. Data is not sent.
+	KindNotification
+	// KindData sends only the data object, so that the app handles the
+	// message itself, e.g. a background sync. Notification is not sent;
+	// platform specific notifications in Android, APNS or Webpush still are.
+	KindData
+	// KindBoth sends the notification object, which must have a title or a
+	// body, and the data object.
+	KindBoth
+)
+
+// Priority is the delivery priority of a message.
+// See more on https://firebase.google.com/docs/cloud-messaging/concept-options#setting-the-priority-of-a-message
+type Priority string
+
+const (
+	// PriorityHigh delivers the message immediately, waking a sleeping
+	// device. Use it for the notifications the user expects to see.
+	PriorityHigh Priority = "high"
+	// PriorityNormal delivers the message immediately to an awake device,
+	// but may delay it while the device is in Doze to save the battery.
+	PriorityNormal Priority = "normal"
+)
+
+// valid reports whether p is empty or one of the Priority constants.
+func (p Priority) valid() bool {
+	return p == "" || p == PriorityHigh || p == PriorityNormal
+}
+
+// The visibilities of an Android notification on a secure lock screen.
+// See more on https://firebase.google.com/docs/reference/fcm/rest/v1/projects.messages#visibility
+const (
+	// VisibilityPrivate shows the notification on the lock screen but hides
+	// its content. It is the default of Android.
+	VisibilityPrivate = "PRIVATE"
+	// VisibilityPublic shows the notification in full on the lock screen.
+	VisibilityPublic = "PUBLIC"
+	// VisibilitySecret does not show the notification on the lock screen.
+	VisibilitySecret = "SECRET"
+)
+
 type Notification struct {
 	Title       string `json:"title"`
 	Body        string `json:"body"`
 	ClickAction string `json:"click_action"`
 	Tag         string `json:"tag"`
+
+	// Image is the https URL of an image shown in the notification on all
+	// platforms, see Client.VerifyImageURL.
+	Image string `json:"image,omitempty"`
+}
+
+// targets returns the registration IDs of m, or a single empty token when m
+// is sent to its Topic or Condition.
+func (m *Message) targets() []string {
+	if m.Topic != "" || m.Condition != "" {
+		return []string{""}
+	}
+	return m.RegistrationIDs
 }
 
 // NewMessage returns a new Message with the specified payload
@@ -64,35 +424,1064 @@ func NewMessage(data map[string]interface{}, regIDs ...string) *Message {
 	return &Message{RegistrationIDs: regIDs, Data: data}
 }
 
-// validate validates message format. If not well-formated returns error.
+// Clone returns a deep copy of m, which shares no maps, slices or pointers
+// with m. The values of Data are copied as they are.
+func (m *Message) Clone() *Message {
+	c := *m
+	c.RegistrationIDs = cloneStrings(m.RegistrationIDs)
+	if m.Data != nil {
+		c.Data = make(map[string]interface{}, len(m.Data))
+		for k, v := range m.Data {
+			c.Data[k] = v
+		}
+	}
+
+	if m.Android != nil {
+		android := *m.Android
+		if m.Android.Notification != nil {
+			n := *m.Android.Notification
+			n.TitleLocArgs = cloneStrings(n.TitleLocArgs)
+			n.BodyLocArgs = cloneStrings(n.BodyLocArgs)
+			n.VibrateTimings = cloneStrings(n.VibrateTimings)
+			if n.LightSettings != nil {
+				lightSettings := *n.LightSettings
+				n.LightSettings = &lightSettings
+			}
+			if n.NotificationCount != nil {
+				count := *n.NotificationCount
+				n.NotificationCount = &count
+			}
+			android.Notification = &n
+		}
+		c.Android = &android
+	}
+
+	if m.APNS != nil {
+		apns := *m.APNS
+		apns.Headers = cloneHeaders(m.APNS.Headers)
+		if m.APNS.Payload != nil {
+			p := *m.APNS.Payload
+			if p.Aps.Alert != nil {
+				alert := *p.Aps.Alert
+				alert.TitleLocArgs = cloneStrings(alert.TitleLocArgs)
+				alert.LocArgs = cloneStrings(alert.LocArgs)
+				p.Aps.Alert = &alert
+			}
+			if p.Aps.Badge != nil {
+				badge := *p.Aps.Badge
+				p.Aps.Badge = &badge
+			}
+			apns.Payload = &p
+		}
+		if m.APNS.FCMOptions != nil {
+			o := *m.APNS.FCMOptions
+			apns.FCMOptions = &o
+		}
+		if m.APNS.RawPayload != nil {
+			apns.RawPayload = append(json.RawMessage(nil), m.APNS.RawPayload...)
+		}
+		c.APNS = &apns
+	}
+
+	if m.Webpush != nil {
+		c.Webpush = newWebpush(m.Webpush)
+		c.Webpush.Headers = cloneHeaders(m.Webpush.Headers)
+	}
+	return &c
+}
+
+// cloneStrings returns a copy of s, nil if s is nil.
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+// cloneHeaders returns a copy of headers, nil if headers is nil.
+func cloneHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	c := make(map[string]string, len(headers))
+	for k, v := range headers {
+		c[k] = v
+	}
+	return c
+}
+
+// SetTimeToLive sets how long (in seconds) the message is kept in FCM storage
+// while the device is offline. Unlike assigning TimeToLive directly, a value
+// of 0 set here is sent to FCM as "0s", which means the message is delivered
+// immediately or dropped. An unset TimeToLive of 0 is omitted from the
+// payload and FCM applies its default of 4 weeks.
+func (m *Message) SetTimeToLive(seconds int) {
+	m.TimeToLive = seconds
+	m.timeToLiveSet = true
+}
+
+// SetTTL sets how long the message is kept in FCM storage while the device
+// is offline, like SetTimeToLive. FCM accepts whole seconds, so d is rounded
+// down; a positive duration under one second is rejected rather than being
+// truncated to "deliver now or drop". d must not exceed 4 weeks.
+func (m *Message) SetTTL(d time.Duration) error {
+	if d < 0 || d > maxTimeToLive*time.Second {
+		return fmt.Errorf("the TTL must be between 0 and %s (4 weeks)", maxTimeToLive*time.Second)
+	}
+
+	if 0 < d && d < time.Second {
+		return fmt.Errorf("the TTL %s is shorter than one second and would be truncated to zero", d)
+	}
+
+	m.SetTimeToLive(int(d / time.Second))
+	return nil
+}
+
+// SetMutableContent sets "mutable-content" in the APNs payload, which lets a
+// Notification Service Extension modify the notification (e.g. to download
+// an image attachment) before it is displayed on iOS.
+func (m *Message) SetMutableContent(mutable bool) {
+	m.apnsPayload().Aps.MutableContent = boolToInt(mutable)
+}
+
+// SetAPNSImage sets the https URL of an image shown in the notification on
+// iOS. The image is downloaded on the device by the Notification Service
+// Extension of the app with the FCM SDK, so "mutable-content" is set too.
+func (m *Message) SetAPNSImage(imageURL string) {
+	m.SetMutableContent(true)
+	m.APNS.FCMOptions = &APNSFCMOptions{Image: imageURL}
+}
+
+// SetContentAvailable sets "content-available" in the APNs payload, which
+// wakes the app to refresh content in the background on iOS. Apple requires
+// background notifications to be sent with low priority, so the
+// "apns-priority" header is set to 5 unless it is given in APNS.Headers.
+func (m *Message) SetContentAvailable(available bool) {
+	m.apnsPayload().Aps.ContentAvailable = boolToInt(available)
+}
+
+// SetAPNSCollapseFromCollapseKey makes CollapseKey collapse the notifications
+// on iOS too by sending it as the apns-collapse-id header, unless the header
+// is given in APNS.Headers. Apple limits the header to 64 bytes.
+func (m *Message) SetAPNSCollapseFromCollapseKey() {
+	m.apnsCollapseFromKey = true
+}
+
+// SetAPNSExpirationFromTTL makes the TTL of the message limit how long APNs
+// stores the notification too by sending the apns-expiration header, unless
+// it is given in APNS.Headers. The expiration is computed from the TTL when
+// the message is sent; a zero TTL set by SetTimeToLive or SetTTL is sent as
+// 0, which makes APNs deliver it immediately or drop it. Without a TTL no
+// header is sent.
+func (m *Message) SetAPNSExpirationFromTTL() {
+	m.apnsExpirationFromTTL = true
+}
+
+// SetAPNSPushType sets the apns-push-type header, overriding the type derived
+// from the payload: "background" for a content-available notification
+// without an alert, sound or badge and "alert" otherwise.
+func (m *Message) SetAPNSPushType(pushType string) {
+	if m.APNS == nil {
+		m.APNS = &APNS{}
+	}
+	if m.APNS.Headers == nil {
+		m.APNS.Headers = make(map[string]string)
+	}
+	m.APNS.Headers[apnsPushTypeHeader] = pushType
+}
+
+func (m *Message) apnsPayload() *APNSPayload {
+	if m.APNS == nil {
+		m.APNS = &APNS{}
+	}
+	if m.APNS.Payload == nil {
+		m.APNS.Payload = &APNSPayload{}
+	}
+	return m.APNS.Payload
+}
+
+// durationErrors returns a ValidationError per malformed duration of the
+// vibration and light settings of n.
+func (n *AndroidNotification) durationErrors() []error {
+	var errs []error
+	for i, timing := range n.VibrateTimings {
+		if !durationPattern.MatchString(timing) {
+			errs = append(errs, &ValidationError{
+				Field:  fmt.Sprintf("Android.Notification.VibrateTimings[%d]", i),
+				Reason: fmt.Sprintf("%q is not a duration like \"0.5s\"", timing),
+			})
+		}
+	}
+
+	if l := n.LightSettings; l != nil {
+		durations := []struct{ field, d string }{
+			{"LightOnDuration", l.LightOnDuration},
+			{"LightOffDuration", l.LightOffDuration},
+		}
+		for _, d := range durations {
+			if !durationPattern.MatchString(d.d) {
+				errs = append(errs, &ValidationError{
+					Field:  "Android.Notification.LightSettings." + d.field,
+					Reason: fmt.Sprintf("%q is not a duration like \"0.5s\"", d.d),
+				})
+			}
+		}
+	}
+
+	return errs
+}
+
+// locArgsErrors returns a ValidationError for the given loc args without the
+// loc key they format.
+func locArgsErrors(field, locKey string, locArgs []string) []error {
+	if len(locArgs) == 0 || locKey != "" {
+		return nil
+	}
+	return []error{&ValidationError{Field: field, Reason: "loc args need a loc key"}}
+}
+
+// analyticsLabelErrors returns a ValidationError for a malformed analytics
+// label.
+func analyticsLabelErrors(field, label string) []error {
+	if label == "" || analyticsLabelPattern.MatchString(label) {
+		return nil
+	}
+	return []error{&ValidationError{
+		Field:  field,
+		Reason: fmt.Sprintf("%q is not an analytics label matching %s", label, analyticsLabelPattern),
+	}}
+}
+
+// apnsCollapseID returns the apns-collapse-id header m is sent with, if any.
+func (m *Message) apnsCollapseID() string {
+	if m.APNS != nil {
+		if id, ok := m.APNS.Headers[apnsCollapseIDHeader]; ok {
+			return id
+		}
+	}
+	if m.apnsCollapseFromKey {
+		return m.CollapseKey
+	}
+	return ""
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// SetDirectBootOk lets the message be delivered to the app while an Android
+// device is in Direct Boot mode, i.e. after a reboot before it is unlocked.
+func (m *Message) SetDirectBootOk(ok bool) {
+	if m.Android == nil {
+		m.Android = &Android{}
+	}
+	m.Android.DirectBootOk = ok
+}
+
+// SetAndroidNotification overrides the notification title and body on Android.
+func (m *Message) SetAndroidNotification(title, body string) {
+	n := m.androidNotification()
+	n.Title = title
+	n.Body = body
+}
+
+// SetAPNSNotification overrides the notification title and body on iOS.
+func (m *Message) SetAPNSNotification(title, body string) {
+	m.apnsPayload().Aps.Alert = &ApsAlert{Title: title, Body: body}
+}
+
+// SetLocalizedTitle sets the notification title to the localized string of
+// locKey formatted with locArgs on Android and iOS. title is the default
+// displayed by devices without the string, so that they do not show a blank
+// notification.
+func (m *Message) SetLocalizedTitle(title, locKey string, locArgs ...string) {
+	m.Notification.Title = title
+	n := m.androidNotification()
+	n.TitleLocKey, n.TitleLocArgs = locKey, locArgs
+	alert := m.apsAlert()
+	alert.Title, alert.TitleLocKey, alert.TitleLocArgs = title, locKey, locArgs
+}
+
+// SetLocalizedBody is like SetLocalizedTitle for the notification body.
+func (m *Message) SetLocalizedBody(body, locKey string, locArgs ...string) {
+	m.Notification.Body = body
+	n := m.androidNotification()
+	n.BodyLocKey, n.BodyLocArgs = locKey, locArgs
+	alert := m.apsAlert()
+	alert.Body, alert.LocKey, alert.LocArgs = body, locKey, locArgs
+}
+
+// apsAlert returns the alert of the APNs payload, created with the title and
+// body of the notification if it is not set.
+func (m *Message) apsAlert() *ApsAlert {
+	aps := &m.apnsPayload().Aps
+	if aps.Alert == nil {
+		aps.Alert = &ApsAlert{Title: m.Notification.Title, Body: m.Notification.Body}
+	}
+	return aps.Alert
+}
+
+// SetSound sets the sound played when the notification is displayed on
+// Android and iOS: "default" for the default system sound, or the name of a
+// sound resource bundled in the app. An empty sound plays none.
+func (m *Message) SetSound(sound string) {
+	if sound == "" && m.Android == nil && m.APNS == nil {
+		return
+	}
+	m.androidNotification().Sound = sound
+	m.apnsPayload().Aps.Sound = sound
+}
+
+// SetDefaultSound makes the notification play the default sound of the
+// Android framework. A sound set with SetSound wins over it.
+func (m *Message) SetDefaultSound(defaultSound bool) {
+	m.androidNotification().DefaultSound = defaultSound
+}
+
+// SetVibrateTimings sets the vibration pattern of the notification on
+// Android: the first duration is the delay before the vibrator turns on,
+// the next one how long it stays on, and so on.
+func (m *Message) SetVibrateTimings(timings ...time.Duration) {
+	n := m.androidNotification()
+	n.VibrateTimings = make([]string, 0, len(timings))
+	for _, d := range timings {
+		n.VibrateTimings = append(n.VibrateTimings, formatDuration(d))
+	}
+}
+
+// SetLightSettings sets the color and the blinking pattern of the
+// notification LED on Android.
+func (m *Message) SetLightSettings(color Color, on, off time.Duration) {
+	m.androidNotification().LightSettings = &LightSettings{
+		Color:            color,
+		LightOnDuration:  formatDuration(on),
+		LightOffDuration: formatDuration(off),
+	}
+}
+
+// formatDuration formats d as a protobuf JSON duration, e.g. "0.5s".
+func formatDuration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// SetAPNSRawPayload sets the APNs payload fields not modeled by APNSPayload,
+// see APNS.RawPayload.
+func (m *Message) SetAPNSRawPayload(payload map[string]interface{}) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if m.APNS == nil {
+		m.APNS = &APNS{}
+	}
+	m.APNS.RawPayload = raw
+	return nil
+}
+
+// SetNotificationCount sets the number shown on the app icon by Android
+// launchers supporting it, like the badge on iOS. A count of 0 is sent too.
+func (m *Message) SetNotificationCount(n int) {
+	m.androidNotification().NotificationCount = &n
+}
+
+// SetEventTime sets the time of the event the notification is about on
+// Android, e.g. the start of a calendar event.
+func (m *Message) SetEventTime(t time.Time) {
+	m.androidNotification().EventTime = t
+}
+
+// SetSticky keeps the notification on Android when the user taps it.
+func (m *Message) SetSticky(sticky bool) {
+	m.androidNotification().Sticky = sticky
+}
+
+// SetVisibility sets how much of the notification is shown on a secure lock
+// screen on Android, e.g. VisibilitySecret for sensitive content.
+func (m *Message) SetVisibility(visibility string) {
+	m.androidNotification().Visibility = visibility
+}
+
+// SetTicker sets the text accessibility services announce when the
+// notification arrives on Android.
+func (m *Message) SetTicker(ticker string) {
+	m.androidNotification().Ticker = ticker
+}
+
+// SetSubText sets the additional text shown below the notification body on
+// Android.
+func (m *Message) SetSubText(subText string) {
+	m.androidNotification().SubText = subText
+}
+
+// SetBadge sets the badge of the app icon on iOS. SetBadge(0) clears the
+// badge; without SetBadge the badge is left unchanged.
+func (m *Message) SetBadge(n int) {
+	m.apnsPayload().Aps.Badge = &n
+}
+
+// SetAPNSThreadID sets the thread-id grouping the notification with the
+// others of the same thread on iOS.
+func (m *Message) SetAPNSThreadID(threadID string) {
+	m.apnsPayload().Aps.ThreadID = threadID
+}
+
+// SetAPNSCategory sets the category selecting the UNNotificationCategory,
+// i.e. the action buttons, of the notification on iOS. The category must
+// not be empty.
+func (m *Message) SetAPNSCategory(category string) {
+	m.apnsPayload().Aps.Category = category
+	m.apnsCategorySet = true
+}
+
+// SetWebpushNotification overrides the notification title and body for web push.
+func (m *Message) SetWebpushNotification(title, body string) {
+	if m.Webpush == nil {
+		m.Webpush = &Webpush{}
+	}
+	m.Webpush.Notification = &WebpushNotification{Title: title, Body: body}
+}
+
+// SetWebpushAnalyticsLabel sets the analytics label of web push deliveries,
+// which is separate from AnalyticsLabel to tell web and mobile apart.
+func (m *Message) SetWebpushAnalyticsLabel(label string) {
+	if m.Webpush == nil {
+		m.Webpush = &Webpush{}
+	}
+	m.Webpush.FCMOptions = &WebpushFCMOptions{AnalyticsLabel: label}
+}
+
+func (m *Message) androidNotification() *AndroidNotification {
+	if m.Android == nil {
+		m.Android = &Android{}
+	}
+	if m.Android.Notification == nil {
+		m.Android.Notification = &AndroidNotification{}
+	}
+	return m.Android.Notification
+}
+
+// newAndroid returns the android options of msg, a copy of msg.Android merged
+// with the Android related fields of msg. msg is not modified.
+func newAndroid(msg *Message) Android {
+	var android Android
+	var notification AndroidNotification
+	if msg.Android != nil {
+		android = *msg.Android
+		if msg.Android.Notification != nil {
+			notification = *msg.Android.Notification
+			notification.VibrateTimings = append([]string(nil), notification.VibrateTimings...)
+			notification.TitleLocArgs = append([]string(nil), notification.TitleLocArgs...)
+			notification.BodyLocArgs = append([]string(nil), notification.BodyLocArgs...)
+			if notification.LightSettings != nil {
+				lightSettings := *notification.LightSettings
+				notification.LightSettings = &lightSettings
+			}
+		}
+	}
+
+	if notification.Tag == "" {
+		notification.Tag = msg.Notification.Tag
+	}
+	if notification.ClickAction == "" {
+		notification.ClickAction = msg.Notification.ClickAction
+	}
+	if !reflect.DeepEqual(notification, AndroidNotification{}) {
+		android.Notification = &notification
+	} else {
+		android.Notification = nil
+	}
+
+	if android.Priority == "" {
+		android.Priority = msg.Priority
+	}
+	if android.CollapseKey == "" {
+		android.CollapseKey = msg.CollapseKey
+	}
+	if android.RestrictedPackageName == "" {
+		android.RestrictedPackageName = msg.RestrictedPackageName
+	}
+	if android.TTL == "" && (msg.TimeToLive != 0 || msg.timeToLiveSet) {
+		android.TTL = fmt.Sprintf("%ds", msg.TimeToLive)
+	}
+
+	return android
+}
+
+// newWebpush returns a copy of webpush. webpush is not modified.
+func newWebpush(webpush *Webpush) *Webpush {
+	if webpush == nil {
+		return nil
+	}
+
+	w := *webpush
+	if webpush.Notification != nil {
+		n := *webpush.Notification
+		w.Notification = &n
+	}
+	if webpush.FCMOptions != nil {
+		o := *webpush.FCMOptions
+		w.FCMOptions = &o
+	}
+	return &w
+}
+
+// newAPNS returns a copy of msg.APNS with the headers derived from the
+// payload and the other fields of msg, computing the expiration from now.
+// msg is not modified.
+func newAPNS(msg *Message, now time.Time) *APNS {
+	var apns APNS
+	if msg.APNS != nil {
+		apns = *msg.APNS
+	}
+
+	headers := make(map[string]string, len(apns.Headers))
+	for k, v := range apns.Headers {
+		headers[k] = v
+	}
+
+	if apns.Payload != nil {
+		p := *apns.Payload
+		apns.Payload = &p
+
+		if _, ok := headers[apnsPriorityHeader]; !ok && p.Aps.ContentAvailable == 1 {
+			headers[apnsPriorityHeader] = apnsPriorityLow
+		}
+	}
+
+	if _, ok := headers[apnsPriorityHeader]; !ok {
+		switch msg.Priority {
+		case PriorityHigh:
+			headers[apnsPriorityHeader] = apnsPriorityHigh
+		case PriorityNormal:
+			headers[apnsPriorityHeader] = apnsPriorityLow
+		}
+	}
+
+	if _, ok := headers[apnsCollapseIDHeader]; !ok && msg.apnsCollapseFromKey && msg.CollapseKey != "" {
+		headers[apnsCollapseIDHeader] = msg.CollapseKey
+	}
+
+	if _, ok := headers[apnsExpirationHeader]; !ok && msg.apnsExpirationFromTTL {
+		switch {
+		case msg.TimeToLive != 0:
+			headers[apnsExpirationHeader] = strconv.FormatInt(now.Add(time.Duration(msg.TimeToLive)*time.Second).Unix(), 10)
+		case msg.timeToLiveSet:
+			headers[apnsExpirationHeader] = "0"
+		}
+	}
+
+	if msg.APNS == nil && len(headers) == 0 {
+		return nil
+	}
+	if _, ok := headers[apnsPushTypeHeader]; !ok {
+		headers[apnsPushTypeHeader] = apnsPushTypeAlert
+		if isBackgroundNotification(msg, apns.Payload) {
+			headers[apnsPushTypeHeader] = apnsPushTypeBackground
+		}
+	}
+	apns.Headers = headers
+	return &apns
+}
+
+// isBackgroundNotification reports whether msg only wakes the app on iOS,
+// i.e. it is content-available without anything to show the user.
+func isBackgroundNotification(msg *Message, payload *APNSPayload) bool {
+	if payload == nil || payload.Aps.ContentAvailable != 1 {
+		return false
+	}
+	if payload.Aps.Alert != nil || payload.Aps.Sound != "" || payload.Aps.Badge != nil {
+		return false
+	}
+	return msg.Notification.Title == "" && msg.Notification.Body == ""
+}
+
+// apnsPushTypes are the values of the apns-push-type header APNs accepts.
+var apnsPushTypes = map[string]bool{
+	"alert":        true,
+	"background":   true,
+	"location":     true,
+	"voip":         true,
+	"complication": true,
+	"fileprovider": true,
+	"mdm":          true,
+	"liveactivity": true,
+	"pushtotalk":   true,
+}
+
+// newData converts the data payload into the string values FCM requires.
+// Values other than strings must have been checked by dataErrors.
+func newData(data map[string]interface{}) map[string]string {
+	if len(data) == 0 {
+		return nil
+	}
+
+	v1Data := make(map[string]string, len(data))
+	for k, v := range data {
+		if s, ok := v.(string); ok {
+			v1Data[k] = s
+			continue
+		}
+		v1Data[k] = fmt.Sprint(v)
+	}
+	return v1Data
+}
+
+// dataErrors checks that every value of data is a string, as FCM rejects
+// any other value, and returns a ValidationError per rejected value ordered by
+// key. With stringify, booleans and numbers are accepted too and sent as
+// their string representation.
+func dataErrors(data map[string]interface{}, stringify bool) []error {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, k := range keys {
+		switch data[k].(type) {
+		case string:
+			continue
+		case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+			if stringify {
+				continue
+			}
+		}
+		errs = append(errs, &ValidationError{
+			Field:  fmt.Sprintf("Data[%q]", k),
+			Reason: fmt.Sprintf("the value must be a string, got %T", data[k]),
+		})
+	}
+
+	return errs
+}
+
+// durationPattern is the format of a protobuf JSON duration, e.g. "0.5s".
+var durationPattern = regexp.MustCompile(`^\d+(\.\d{1,9})?s$`)
+
+// maxConditionTopics is the max number of topics FCM accepts in a condition.
+const maxConditionTopics = 5
+
+// topicsCondition returns the condition matching the devices subscribed to
+// all of topics with matchAll, else to any of them.
+func topicsCondition(topics []string, matchAll bool) (string, error) {
+	if len(topics) == 0 {
+		return "", &ValidationError{Field: "topics", Reason: "must not be empty"}
+	}
+	if len(topics) > maxConditionTopics {
+		return "", &ValidationError{Field: "topics", Reason: fmt.Sprintf("a condition can have at most %d topics, got %d", maxConditionTopics, len(topics))}
+	}
+
+	op := " || "
+	if matchAll {
+		op = " && "
+	}
+	terms := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		name := strings.TrimPrefix(topic, "/topics/")
+		if !topicNamePattern.MatchString(name) {
+			return "", &ValidationError{Field: "topics", Reason: fmt.Sprintf("%q is not a topic name matching %s", topic, topicNamePattern)}
+		}
+		terms = append(terms, "'"+name+"' in topics")
+	}
+	return strings.Join(terms, op), nil
+}
+
+// analyticsLabelPattern is the format of an analytics label.
+var analyticsLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9_.~%-]{1,50}$`)
+
+// packageNamePattern is the format of an Android application ID.
+var packageNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*(\.[a-zA-Z][a-zA-Z0-9_]*)+$`)
+
+// reservedDataKeys and reservedDataPrefixes are the Data keys FCM reserves.
+// See more on https://firebase.google.com/docs/cloud-messaging/concept-options#notifications_and_data_messages
+var (
+	reservedDataKeys     = []string{"from", "notification", "message_type"}
+	reservedDataPrefixes = []string{"google", "gcm"}
+)
+
+// notificationDataKeys are the notification fields client libraries also
+// read from the data payload.
+var notificationDataKeys = []string{"title", "body", "click_action", "tag", "sound", "icon", "color"}
+
+// dataConflictErrors returns a ValidationError per Data key that is reserved
+// by FCM or collides with a notification field of the message, ordered by key.
+func (m *Message) dataConflictErrors() []error {
+	keys := make([]string, 0, len(m.Data))
+	for k := range m.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	android := newAndroid(m)
+	hasNotification := m.Notification.Title != "" || m.Notification.Body != "" ||
+		android.Notification != nil ||
+		(m.APNS != nil && m.APNS.Payload != nil && m.APNS.Payload.Aps.Alert != nil) ||
+		(m.Webpush != nil && m.Webpush.Notification != nil)
+
+	var errs []error
+	for _, k := range keys {
+		field := fmt.Sprintf("Data[%q]", k)
+		if reason := reservedDataKeyReason(k); reason != "" {
+			errs = append(errs, &ValidationError{Field: field, Reason: reason})
+			continue
+		}
+		if !hasNotification {
+			continue
+		}
+		for _, n := range notificationDataKeys {
+			if k == n {
+				errs = append(errs, &ValidationError{
+					Field:  field,
+					Reason: fmt.Sprintf("the key collides with the notification field %q; client apps may render the data value instead of the notification", n),
+				})
+				break
+			}
+		}
+	}
+
+	return errs
+}
+
+// strictErrors returns a ValidationError per field of the message that is
+// out of range or would be silently overridden or dropped, see
+// Client.StrictValidation.
+func (m *Message) strictErrors() []error {
+	var errs []error
+
+	if m.Kind == KindNotification && len(m.Data) > 0 {
+		errs = append(errs, &ValidationError{Field: "Data", Reason: "is not sent with a KindNotification message"})
+	}
+	if m.Kind == KindData && (m.Notification.Title != "" || m.Notification.Body != "") {
+		errs = append(errs, &ValidationError{Field: "Notification", Reason: "is not sent with a KindData message"})
+	}
+	if m.DelayWhileIdle {
+		errs = append(errs, &ValidationError{Field: "DelayWhileIdle", Reason: "is not supported by the FCM HTTP v1 API"})
+	}
+
+	if a := m.Android; a != nil {
+		overrides := []struct {
+			field, value, android string
+		}{
+			{"CollapseKey", m.CollapseKey, a.CollapseKey},
+			{"Priority", string(m.Priority), strings.ToLower(string(a.Priority))},
+			{"RestrictedPackageName", m.RestrictedPackageName, a.RestrictedPackageName},
+		}
+		for _, o := range overrides {
+			if o.value != "" && o.android != "" && o.value != o.android {
+				errs = append(errs, &ValidationError{
+					Field:  o.field,
+					Reason: fmt.Sprintf("%q is overridden by Android.%s %q", o.value, o.field, o.android),
+				})
+			}
+		}
+
+		if a.TTL != "" {
+			if !durationPattern.MatchString(a.TTL) {
+				errs = append(errs, &ValidationError{Field: "Android.TTL", Reason: fmt.Sprintf("%q is not a duration like \"3600s\"", a.TTL)})
+			} else if ttl := fmt.Sprintf("%ds", m.TimeToLive); (m.TimeToLive != 0 || m.timeToLiveSet) && ttl != a.TTL {
+				errs = append(errs, &ValidationError{
+					Field:  "TimeToLive",
+					Reason: fmt.Sprintf("%s is overridden by Android.TTL %q", ttl, a.TTL),
+				})
+			}
+		}
+
+		if a.Notification != nil && a.Notification.LightSettings != nil {
+			c := a.Notification.LightSettings.Color
+			for _, component := range []float64{c.Red, c.Green, c.Blue, c.Alpha} {
+				if component < 0 || component > 1 {
+					errs = append(errs, &ValidationError{
+						Field:  "Android.Notification.LightSettings.Color",
+						Reason: "the components must be between 0 and 1",
+					})
+					break
+				}
+			}
+		}
+	}
+
+	if m.APNS != nil && m.APNS.Payload != nil {
+		aps := m.APNS.Payload.Aps
+		flags := []struct {
+			field string
+			value int
+		}{
+			{"ContentAvailable", aps.ContentAvailable},
+			{"MutableContent", aps.MutableContent},
+		}
+		for _, f := range flags {
+			if f.value != 0 && f.value != 1 {
+				errs = append(errs, &ValidationError{
+					Field:  "APNS.Payload.Aps." + f.field,
+					Reason: fmt.Sprintf("must be 0 or 1, got %d", f.value),
+				})
+			}
+		}
+	}
+
+	return errs
+}
+
+// reservedDataKeyReason returns why key is reserved by FCM, or "".
+func reservedDataKeyReason(key string) string {
+	for _, reserved := range reservedDataKeys {
+		if key == reserved {
+			return fmt.Sprintf("the key %q is reserved by FCM", key)
+		}
+	}
+	for _, prefix := range reservedDataPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return fmt.Sprintf("keys starting with %q are reserved by FCM", prefix)
+		}
+	}
+	return ""
+}
+
+// newMessageV1 converts msg into the FCM HTTP v1 representation addressed
+// to the given registration token as sent at now.
+func newMessageV1(msg *Message, token string, now time.Time) MessageV1 {
+	messageV1 := MessageV1{
+		Token:          token,
+		Data:           newData(msg.Data),
+		DelayWhileIdle: msg.DelayWhileIdle,
+	}
+	if token == "" {
+		messageV1.Topic = strings.TrimPrefix(msg.Topic, "/topics/")
+		messageV1.Condition = msg.Condition
+	}
+	if msg.Kind != KindData {
+		messageV1.Notification = &NotificationV1{Title: msg.Notification.Title, Body: msg.Notification.Body, Image: msg.Notification.Image}
+	}
+	if msg.Kind == KindNotification {
+		messageV1.Data = nil
+	}
+	messageV1.Android = newAndroid(msg)
+	messageV1.APNS = newAPNS(msg, now)
+	messageV1.Webpush = newWebpush(msg.Webpush)
+	if msg.AnalyticsLabel != "" {
+		messageV1.FCMOptions = &FCMOptions{AnalyticsLabel: msg.AnalyticsLabel}
+	}
+
+	return messageV1
+}
+
+// autoCollapseKey returns a collapse key derived from the notification and
+// the data of msg, so that identical messages collapse on the device.
+func autoCollapseKey(msg *Message) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q\x00%q\x00", msg.Notification.Title, msg.Notification.Body)
+
+	keys := make([]string, 0, len(msg.Data))
+	for k := range msg.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "%q=%q\x00", k, fmt.Sprint(msg.Data[k]))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:autoCollapseKeyLength]
+}
+
+// warnings returns the allowed but likely unintended settings of the message.
+func (m *Message) warnings() []string {
+	var warnings []string
+
+	if m.APNS != nil && m.APNS.Payload != nil && m.APNS.Payload.Aps.ContentAvailable == 1 &&
+		(m.Notification.Title != "" || m.Notification.Body != "" || m.APNS.Payload.Aps.Alert != nil) {
+		warnings = append(warnings, "content-available is set on a message with a visible alert; iOS may not wake the app in the background")
+	}
+
+	android := newAndroid(m)
+	visible := m.Notification.Title != "" || m.Notification.Body != "" ||
+		(android.Notification != nil && (android.Notification.Title != "" || android.Notification.Body != ""))
+	if visible && strings.EqualFold(string(android.Priority), string(PriorityNormal)) {
+		warning := "normal priority is set on a notification expected to be displayed; " +
+			"Android may delay it while the device is in Doze, use high priority for user-visible notifications"
+		if m.timeToLiveSet && m.TimeToLive == 0 {
+			warning += ", and with a zero TTL it is dropped instead of being delivered later"
+		}
+		warnings = append(warnings, warning)
+	}
+
+	return warnings
+}
+
+// validate validates message format. If not well-formated returns the
+// ValidationErrors of all the invalid fields joined into one error.
 func (m *Message) validate() error {
+	return errors.Join(m.fieldErrors(maxSendTokens)...)
+}
+
+// fieldErrors validates message format allowing at most maxTokens
+// registration IDs and returns a ValidationError per invalid field.
+func (m *Message) fieldErrors(maxTokens int) []error {
 	if m == nil {
-		return fmt.Errorf("the message must not be nil")
+		return []error{&ValidationError{Field: "Message", Reason: "the message must not be nil"}}
+	}
+
+	var errs []error
+
+	var targets []string
+	if len(m.RegistrationIDs) > 0 {
+		targets = append(targets, "RegistrationIDs")
+	}
+	if m.Topic != "" {
+		targets = append(targets, "Topic")
+	}
+	if m.Condition != "" {
+		targets = append(targets, "Condition")
 	}
 
-	if m.RegistrationIDs == nil {
-		return fmt.Errorf("the message's RegistrationIDs field must not be nil")
+	switch {
+	case len(targets) > 1:
+		errs = append(errs, &ValidationError{
+			Field:  strings.Join(targets, ", "),
+			Reason: fmt.Sprintf("the message must have a single target, but %s are set", strings.Join(targets, " and ")),
+		})
+	case len(targets) == 0 && m.RegistrationIDs == nil:
+		errs = append(errs, &ValidationError{Field: "RegistrationIDs", Reason: "must not be nil unless Topic or Condition is set"})
+	case len(targets) == 0:
+		errs = append(errs, &ValidationError{Field: "RegistrationIDs", Reason: "the message must specify at least one registration ID, a Topic or a Condition"})
+	case len(m.RegistrationIDs) > maxTokens:
+		errs = append(errs, &ValidationError{
+			Field:  "RegistrationIDs",
+			Reason: fmt.Sprintf("the message may specify at most %d registration IDs (each is sent as a separate FCM v1 request)", maxTokens),
+		})
 	}
 
-	if len(m.RegistrationIDs) == 0 {
-		return fmt.Errorf("the message must specify at least one registration ID")
+	var emptyTokens []int
+	for i, token := range m.RegistrationIDs {
+		if strings.TrimSpace(token) == "" {
+			emptyTokens = append(emptyTokens, i)
+		}
+	}
+	if len(emptyTokens) > 0 {
+		errs = append(errs, &ValidationError{
+			Field:  "RegistrationIDs",
+			Reason: fmt.Sprintf("the registration IDs at indices %v are empty", emptyTokens),
+		})
 	}
 
-	if len(m.RegistrationIDs) > maxRegistrationIDs {
-		return fmt.Errorf("the message may specify at most %d registration IDs",
-			maxRegistrationIDs)
+	if topic := strings.TrimPrefix(m.Topic, "/topics/"); m.Topic != "" && !topicNamePattern.MatchString(topic) {
+		errs = append(errs, &ValidationError{Field: "Topic", Reason: fmt.Sprintf("%q is not a topic name matching %s", m.Topic, topicNamePattern)})
 	}
 
 	if m.TimeToLive < 0 || maxTimeToLive < m.TimeToLive {
-		return fmt.Errorf(
-			"the message's TimeToLive field must be an integer between 0 and %d (4 weeks)",
-			maxTimeToLive,
-		)
+		errs = append(errs, &ValidationError{
+			Field:  "TimeToLive",
+			Reason: fmt.Sprintf("must be an integer between 0 and %d (4 weeks)", maxTimeToLive),
+		})
 	}
 
-	if m.Priority != "" && m.Priority != fcmPushPriorityHigh && m.Priority != fcmPushPriorityNormal {
-		return fmt.Errorf("priority must be %s or %s", fcmPushPriorityHigh, fcmPushPriorityNormal)
+	if (m.requireNotification || m.Kind == KindNotification || m.Kind == KindBoth) &&
+		m.Notification.Title == "" && m.Notification.Body == "" {
+		errs = append(errs, &ValidationError{Field: "Notification", Reason: "a notification message needs at least a body or a title"})
 	}
 
-	return nil
+	if collapseID := m.apnsCollapseID(); len(collapseID) > maxAPNSCollapseIDLength {
+		errs = append(errs, &ValidationError{
+			Field:  "APNS.Headers[" + apnsCollapseIDHeader + "]",
+			Reason: fmt.Sprintf("must be at most %d bytes, got %d", maxAPNSCollapseIDLength, len(collapseID)),
+		})
+	}
+
+	if m.APNS != nil {
+		if pushType, ok := m.APNS.Headers[apnsPushTypeHeader]; ok && !apnsPushTypes[pushType] {
+			errs = append(errs, &ValidationError{
+				Field:  "APNS.Headers[" + apnsPushTypeHeader + "]",
+				Reason: fmt.Sprintf("%q is not a push type accepted by APNs", pushType),
+			})
+		}
+	}
+
+	errs = append(errs, analyticsLabelErrors("AnalyticsLabel", m.AnalyticsLabel)...)
+	if m.Webpush != nil && m.Webpush.FCMOptions != nil {
+		errs = append(errs, analyticsLabelErrors("Webpush.FCMOptions.AnalyticsLabel", m.Webpush.FCMOptions.AnalyticsLabel)...)
+	}
+
+	for _, image := range m.images() {
+		if u, err := url.Parse(image.url); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, &ValidationError{
+				Field:  image.field,
+				Reason: fmt.Sprintf("%q is not an https URL", image.url),
+			})
+		}
+	}
+
+	if m.APNS != nil && len(m.APNS.RawPayload) != 0 {
+		var raw map[string]interface{}
+		if err := json.Unmarshal(m.APNS.RawPayload, &raw); err != nil {
+			errs = append(errs, &ValidationError{Field: "APNS.RawPayload", Reason: fmt.Sprintf("must be a JSON object: %v", err)})
+		}
+	}
+
+	if m.Android != nil && m.Android.Notification != nil && !m.Android.Notification.EventTime.IsZero() {
+		if year := m.Android.Notification.EventTime.UTC().Year(); year < 1 || year > 9999 {
+			errs = append(errs, &ValidationError{
+				Field:  "Android.Notification.EventTime",
+				Reason: fmt.Sprintf("the year %d can not be formatted as an RFC 3339 timestamp", year),
+			})
+		}
+	}
+
+	if m.Android != nil && m.Android.Notification != nil && m.Android.Notification.NotificationCount != nil && *m.Android.Notification.NotificationCount < 0 {
+		errs = append(errs, &ValidationError{Field: "Android.Notification.NotificationCount", Reason: "must not be negative"})
+	}
+
+	if m.APNS != nil && m.APNS.Payload != nil && m.APNS.Payload.Aps.Badge != nil && *m.APNS.Payload.Aps.Badge < 0 {
+		errs = append(errs, &ValidationError{Field: "APNS.Payload.Aps.Badge", Reason: "must not be negative"})
+	}
+
+	if m.APNS != nil && m.APNS.Payload != nil {
+		if category := m.APNS.Payload.Aps.Category; (m.apnsCategorySet || category != "") && strings.TrimSpace(category) == "" {
+			errs = append(errs, &ValidationError{Field: "APNS.Payload.Aps.Category", Reason: "must not be empty"})
+		}
+	}
+
+	if m.Android != nil && m.Android.Notification != nil {
+		n := m.Android.Notification
+		errs = append(errs, n.durationErrors()...)
+		errs = append(errs, locArgsErrors("Android.Notification.TitleLocArgs", n.TitleLocKey, n.TitleLocArgs)...)
+		errs = append(errs, locArgsErrors("Android.Notification.BodyLocArgs", n.BodyLocKey, n.BodyLocArgs)...)
+		switch n.Visibility {
+		case "", VisibilityPrivate, VisibilityPublic, VisibilitySecret:
+		default:
+			errs = append(errs, &ValidationError{
+				Field:  "Android.Notification.Visibility",
+				Reason: fmt.Sprintf("visibility must be %s, %s or %s", VisibilityPrivate, VisibilityPublic, VisibilitySecret),
+			})
+		}
+	}
+
+	if m.APNS != nil && m.APNS.Payload != nil && m.APNS.Payload.Aps.Alert != nil {
+		alert := m.APNS.Payload.Aps.Alert
+		errs = append(errs, locArgsErrors("APNS.Payload.Aps.Alert.TitleLocArgs", alert.TitleLocKey, alert.TitleLocArgs)...)
+		errs = append(errs, locArgsErrors("APNS.Payload.Aps.Alert.LocArgs", alert.LocKey, alert.LocArgs)...)
+	}
+
+	if name := newAndroid(m).RestrictedPackageName; name != "" && !packageNamePattern.MatchString(name) {
+		errs = append(errs, &ValidationError{
+			Field:  "RestrictedPackageName",
+			Reason: fmt.Sprintf("%q is not an Android package name like com.example.app", name),
+		})
+	}
+
+	if m.Kind < KindDefault || m.Kind > KindBoth {
+		errs = append(errs, &ValidationError{Field: "Kind", Reason: fmt.Sprintf("unknown message kind %d", m.Kind)})
+	}
+
+	if !m.Priority.valid() {
+		errs = append(errs, &ValidationError{
+			Field:  "Priority",
+			Reason: fmt.Sprintf("priority must be %s or %s", PriorityHigh, PriorityNormal),
+		})
+	}
+
+	return errs
 }
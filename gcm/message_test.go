@@ -1,6 +1,15 @@
 package gcm
 
-import "testing"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestValidateMessage(t *testing.T) {
 	cases := []struct {
@@ -109,3 +118,826 @@ func TestValidateMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestNewMessageV1TimeToLive(t *testing.T) {
+	unset := NewMessage(nil, "1")
+	if ttl := newMessageV1(unset, "1", time.Now()).Android.TTL; ttl != "" {
+		t.Fatalf("expect unset TimeToLive to be omitted, got %q", ttl)
+	}
+
+	zero := NewMessage(nil, "1")
+	zero.SetTimeToLive(0)
+	if ttl := newMessageV1(zero, "1", time.Now()).Android.TTL; ttl != "0s" {
+		t.Fatalf("expect explicit zero TimeToLive to be \"0s\", got %q", ttl)
+	}
+
+	legacy := NewMessage(nil, "1")
+	legacy.TimeToLive = 600
+	if ttl := newMessageV1(legacy, "1", time.Now()).Android.TTL; ttl != "600s" {
+		t.Fatalf("expect TimeToLive to be \"600s\", got %q", ttl)
+	}
+}
+
+func TestNewMessageV1APNS(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.SetMutableContent(true)
+	msg.SetContentAvailable(true)
+
+	apns := newMessageV1(msg, "1", time.Now()).APNS
+	if apns == nil || apns.Payload == nil {
+		t.Fatalf("expect APNs payload to be set")
+	}
+	if apns.Payload.Aps.MutableContent != 1 || apns.Payload.Aps.ContentAvailable != 1 {
+		t.Fatalf("expect mutable-content and content-available to be 1, got %+v", apns.Payload.Aps)
+	}
+	if apns.Headers[apnsPriorityHeader] != apnsPriorityLow {
+		t.Fatalf("expect apns-priority to be %s, got %q", apnsPriorityLow, apns.Headers[apnsPriorityHeader])
+	}
+	if msg.APNS.Headers != nil {
+		t.Fatalf("expect the original message headers not to be modified")
+	}
+
+	if len(msg.warnings()) != 0 {
+		t.Fatalf("expect no warnings for a silent notification")
+	}
+	msg.Notification.Body = "visible"
+	if len(msg.warnings()) != 1 {
+		t.Fatalf("expect a warning for content-available with a visible alert")
+	}
+
+	msg.SetContentAvailable(false)
+	if h := newMessageV1(msg, "1", time.Now()).APNS.Headers; h[apnsPriorityHeader] != "" {
+		t.Fatalf("expect no apns-priority without content-available, got %v", h)
+	}
+}
+
+func TestAPNSPushType(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	if apns := newMessageV1(msg, "1", time.Now()).APNS; apns != nil {
+		t.Fatalf("expect no apns options by default, got %+v", apns)
+	}
+
+	msg.SetContentAvailable(true)
+	if h := newMessageV1(msg, "1", time.Now()).APNS.Headers[apnsPushTypeHeader]; h != apnsPushTypeBackground {
+		t.Fatalf("expect push type %s for a silent notification, got %q", apnsPushTypeBackground, h)
+	}
+
+	msg.SetBadge(1)
+	if h := newMessageV1(msg, "1", time.Now()).APNS.Headers[apnsPushTypeHeader]; h != apnsPushTypeAlert {
+		t.Fatalf("expect push type %s for a visible notification, got %q", apnsPushTypeAlert, h)
+	}
+
+	msg.SetAPNSPushType("voip")
+	if h := newMessageV1(msg, "1", time.Now()).APNS.Headers[apnsPushTypeHeader]; h != "voip" {
+		t.Fatalf("expect an explicit push type to be kept, got %q", h)
+	}
+	if err := msg.validate(); err != nil {
+		t.Fatalf("expect voip to be valid: %v", err)
+	}
+
+	msg.SetAPNSPushType("unknown")
+	var verr *ValidationError
+	if err := msg.validate(); !errors.As(err, &verr) || verr.Field != "APNS.Headers[apns-push-type]" {
+		t.Fatalf("expect an unknown push type to be invalid, got %v", err)
+	}
+}
+
+func TestNormalPriorityWarning(t *testing.T) {
+	cases := []struct {
+		msg      *Message
+		warnings int
+	}{
+		{&Message{Priority: "normal"}, 0},
+		{&Message{Priority: "high", Notification: Notification{Body: "hello"}}, 0},
+		{&Message{Priority: "normal", Notification: Notification{Body: "hello"}}, 1},
+		{&Message{Priority: "high", Android: &Android{Priority: "normal", Notification: &AndroidNotification{Title: "hello"}}}, 1},
+	}
+
+	for i, tc := range cases {
+		if got := len(tc.msg.warnings()); got != tc.warnings {
+			t.Fatalf("#%d expect %d warnings, got %d", i, tc.warnings, got)
+		}
+	}
+}
+
+func TestNewMessageV1APNSCollapseAndExpiration(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.CollapseKey = "score"
+	msg.SetTimeToLive(3600)
+	if apns := newMessageV1(msg, "1", time.Now()).APNS; apns != nil {
+		t.Fatalf("expect no apns headers by default, got %+v", apns)
+	}
+
+	msg.SetAPNSCollapseFromCollapseKey()
+	msg.SetAPNSExpirationFromTTL()
+	now := time.Unix(1600000000, 0)
+	headers := newMessageV1(msg, "1", now).APNS.Headers
+	if headers[apnsCollapseIDHeader] != "score" {
+		t.Fatalf("expect apns-collapse-id to be the collapse key, got %q", headers[apnsCollapseIDHeader])
+	}
+	expiration, err := strconv.ParseInt(headers[apnsExpirationHeader], 10, 64)
+	if err != nil || expiration != now.Unix()+3600 {
+		t.Fatalf("expect apns-expiration to be an hour later, got %q", headers[apnsExpirationHeader])
+	}
+	if msg.APNS != nil {
+		t.Fatalf("expect the original message not to be modified")
+	}
+
+	msg.SetTimeToLive(0)
+	if h := newMessageV1(msg, "1", time.Now()).APNS.Headers[apnsExpirationHeader]; h != "0" {
+		t.Fatalf("expect apns-expiration 0 for a zero TTL, got %q", h)
+	}
+
+	msg.APNS = &APNS{Headers: map[string]string{apnsCollapseIDHeader: "explicit"}}
+	if h := newMessageV1(msg, "1", time.Now()).APNS.Headers[apnsCollapseIDHeader]; h != "explicit" {
+		t.Fatalf("expect an explicit apns-collapse-id to be kept, got %q", h)
+	}
+
+	msg.APNS = nil
+	msg.CollapseKey = strings.Repeat("a", 65)
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (apns-collapse-id longer than 64 bytes)")
+	}
+}
+
+func TestSetSound(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.SetSound("default")
+
+	messageV1 := newMessageV1(msg, "1", time.Now())
+	if messageV1.Android.Notification.Sound != "default" {
+		t.Fatalf("expect android sound to be default, got %+v", messageV1.Android.Notification)
+	}
+	if messageV1.APNS.Payload.Aps.Sound != "default" {
+		t.Fatalf("expect aps sound to be default, got %+v", messageV1.APNS.Payload.Aps)
+	}
+
+	msg.SetSound("chime.caf")
+	if got := newMessageV1(msg, "1", time.Now()).APNS.Payload.Aps.Sound; got != "chime.caf" {
+		t.Fatalf("expect aps sound to be chime.caf, got %q", got)
+	}
+
+	silent := NewMessage(nil, "1")
+	silent.SetSound("")
+	if silent.Android != nil || silent.APNS != nil {
+		t.Fatalf("expect no platform options for an empty sound")
+	}
+}
+
+func TestSetBadge(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.SetBadge(0)
+
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"aps":{"badge":0}`) {
+		t.Fatalf("expect a zero badge to be sent, got %s", b)
+	}
+
+	msg.SetBadge(-1)
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (negative badge)")
+	}
+}
+
+func TestSetNotificationCount(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.SetNotificationCount(0)
+
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"notification":{"notification_count":0}`) {
+		t.Fatalf("expect a zero notification count to be sent, got %s", b)
+	}
+
+	msg.SetNotificationCount(-1)
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (negative notification count)")
+	}
+}
+
+func TestRestrictedPackageName(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.RestrictedPackageName = "com.example.app"
+	if err := msg.validate(); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	var marshaled struct {
+		RestrictedPackageName *string `json:"restricted_package_name"`
+		Android               struct {
+			RestrictedPackageName string `json:"restricted_package_name"`
+		} `json:"android"`
+	}
+	if err := json.Unmarshal(b, &marshaled); err != nil {
+		t.Fatalf("failed to unmarshal the message: %v", err)
+	}
+	if marshaled.RestrictedPackageName != nil || marshaled.Android.RestrictedPackageName != "com.example.app" {
+		t.Fatalf("expect restricted_package_name in the android block only, got %s", b)
+	}
+
+	msg.RestrictedPackageName = "not a package"
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (invalid package name)")
+	}
+}
+
+func TestVibrateAndLightSettings(t *testing.T) {
+	msg, err := NewMessageBuilder().
+		AddToken("1").
+		WithVibrateTimings(0, 500*time.Millisecond, 2*time.Second).
+		WithLightSettings(Color{Green: 1}, time.Second, 1500*time.Millisecond).
+		Build()
+	if err != nil {
+		t.Fatalf("expect Build() to be success: %v", err)
+	}
+
+	n := newMessageV1(msg, "1", time.Now()).Android.Notification
+	if got := fmt.Sprint(n.VibrateTimings); got != "[0s 0.5s 2s]" {
+		t.Fatalf("unexpected vibrate timings: %s", got)
+	}
+	if n.LightSettings.LightOnDuration != "1s" || n.LightSettings.LightOffDuration != "1.5s" {
+		t.Fatalf("unexpected light settings: %+v", n.LightSettings)
+	}
+
+	msg.Android.Notification.VibrateTimings = []string{"500ms"}
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (malformed vibrate timing)")
+	}
+
+	msg.Android.Notification.VibrateTimings = nil
+	msg.Android.Notification.LightSettings.LightOnDuration = "1"
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (malformed light duration)")
+	}
+}
+
+func TestAPNSRawPayload(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.SetBadge(1)
+	if err := msg.SetAPNSRawPayload(map[string]interface{}{
+		"aps":        map[string]interface{}{"interruption-level": "critical", "relevance-score": 0.5},
+		"custom-key": "value",
+	}); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if err := msg.validate(); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	var marshaled struct {
+		APNS struct {
+			Payload map[string]interface{} `json:"payload"`
+		} `json:"apns"`
+	}
+	if err := json.Unmarshal(b, &marshaled); err != nil {
+		t.Fatalf("failed to unmarshal the message: %v", err)
+	}
+	aps, _ := marshaled.APNS.Payload["aps"].(map[string]interface{})
+	if aps["badge"] != 1.0 || aps["interruption-level"] != "critical" || aps["relevance-score"] != 0.5 {
+		t.Fatalf("expect the raw aps to be merged with the modeled one, got %s", b)
+	}
+	if marshaled.APNS.Payload["custom-key"] != "value" {
+		t.Fatalf("expect the raw payload keys to be embedded, got %s", b)
+	}
+
+	msg.APNS.RawPayload = []byte(`{"aps":`)
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (invalid JSON)")
+	}
+	msg.APNS.RawPayload = []byte(`["aps"]`)
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (not a JSON object)")
+	}
+}
+
+func TestMessageKind(t *testing.T) {
+	data := map[string]interface{}{"sync": "inbox"}
+	cases := []struct {
+		kind         Kind
+		notification bool
+		data         bool
+	}{
+		{KindDefault, true, true},
+		{KindNotification, true, false},
+		{KindData, false, true},
+		{KindBoth, true, true},
+	}
+
+	for i, tc := range cases {
+		msg := NewMessage(data, "1")
+		msg.Notification.Body = "body"
+		msg.Kind = tc.kind
+		if err := msg.validate(); err != nil {
+			t.Fatalf("#%d expect to be success: %v", i, err)
+		}
+
+		b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+		if err != nil {
+			t.Fatalf("#%d failed to marshal the message: %v", i, err)
+		}
+		var marshaled map[string]interface{}
+		if err := json.Unmarshal(b, &marshaled); err != nil {
+			t.Fatalf("#%d failed to unmarshal the message: %v", i, err)
+		}
+		if _, ok := marshaled["notification"]; ok != tc.notification {
+			t.Fatalf("#%d expect notification to be sent: %v, got %s", i, tc.notification, b)
+		}
+		if _, ok := marshaled["data"]; ok != tc.data {
+			t.Fatalf("#%d expect data to be sent: %v, got %s", i, tc.data, b)
+		}
+	}
+
+	msg := NewMessage(data, "1")
+	msg.Kind = KindNotification
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (notification kind without a notification)")
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	cases := []struct {
+		ttl     time.Duration
+		seconds int
+		success bool
+	}{
+		{0, 0, true},
+		{time.Minute, 60, true},
+		{1500 * time.Millisecond, 1, true},
+		{28 * 24 * time.Hour, maxTimeToLive, true},
+		{500 * time.Millisecond, 0, false},
+		{-time.Second, 0, false},
+		{28*24*time.Hour + time.Second, 0, false},
+	}
+
+	for i, tc := range cases {
+		msg := NewMessage(nil, "1")
+		err := msg.SetTTL(tc.ttl)
+		if err != nil {
+			if tc.success {
+				t.Fatalf("#%d expect SetTTL() to be success: %v", i, err)
+			}
+			continue
+		}
+
+		if !tc.success {
+			t.Fatalf("#%d expect SetTTL() to be failed", i)
+		}
+		if msg.TimeToLive != tc.seconds || !msg.timeToLiveSet {
+			t.Fatalf("#%d expect TimeToLive %d, got %d", i, tc.seconds, msg.TimeToLive)
+		}
+	}
+}
+
+func TestNewMessageV1PlatformNotifications(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.Notification = Notification{Title: "title", Body: "body", Tag: "tag", ClickAction: "action"}
+	msg.Priority = "high"
+	msg.SetAndroidNotification("android title", "android body")
+	msg.SetAPNSNotification("ios title", "ios body")
+	msg.SetWebpushNotification("web title", "web body")
+
+	v1 := newMessageV1(msg, "1", time.Now())
+	if v1.Notification.Title != "title" || v1.Notification.Body != "body" {
+		t.Fatalf("unexpected notification: %+v", v1.Notification)
+	}
+	if n := v1.Android.Notification; n == nil || n.Title != "android title" || n.Body != "android body" || n.Tag != "tag" || n.ClickAction != "action" {
+		t.Fatalf("unexpected android notification: %+v", n)
+	}
+	if v1.Android.Priority != "high" {
+		t.Fatalf("expect android priority high, got %q", v1.Android.Priority)
+	}
+	if a := v1.APNS.Payload.Aps.Alert; a == nil || a.Title != "ios title" || a.Body != "ios body" {
+		t.Fatalf("unexpected apns alert: %+v", a)
+	}
+	if n := v1.Webpush.Notification; n == nil || n.Title != "web title" || n.Body != "web body" {
+		t.Fatalf("unexpected webpush notification: %+v", n)
+	}
+	if msg.Android.Notification.Tag != "" {
+		t.Fatalf("expect the original message not to be modified")
+	}
+
+	if n := newMessageV1(NewMessage(nil, "1"), "1", time.Now()).Android.Notification; n != nil {
+		t.Fatalf("expect no android notification without android options, got %+v", n)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	msg := &Message{
+		RegistrationIDs: []string{},
+		TimeToLive:      -1,
+		Priority:        "urgent",
+	}
+
+	err := msg.validate()
+	if err == nil {
+		t.Fatalf("expect to be failed")
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("expect a joined error, got %T", err)
+	}
+	var fields []string
+	for _, e := range joined.Unwrap() {
+		var validationErr *ValidationError
+		if !errors.As(e, &validationErr) {
+			t.Fatalf("expect a ValidationError, got %T", e)
+		}
+		fields = append(fields, validationErr.Field)
+	}
+	if got, want := fmt.Sprint(fields), "[RegistrationIDs TimeToLive Priority]"; got != want {
+		t.Fatalf("expect invalid fields %s, got %s", want, got)
+	}
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "RegistrationIDs" {
+		t.Fatalf("expect errors.As to find the first ValidationError, got %v", err)
+	}
+}
+
+func TestDataConflictErrors(t *testing.T) {
+	cases := []struct {
+		msg       *Message
+		conflicts int
+	}{
+		{&Message{Data: map[string]interface{}{"score": "5x1", "title": "data title"}}, 0},
+		{&Message{Data: map[string]interface{}{"title": "data title"}, Notification: Notification{Title: "title"}}, 1},
+		{&Message{Data: map[string]interface{}{"from": "me", "google.key": "v", "gcm_key": "v"}}, 3},
+		{&Message{Data: map[string]interface{}{"sound": "chime"}, Webpush: &Webpush{Notification: &WebpushNotification{Body: "body"}}}, 1},
+	}
+
+	for i, tc := range cases {
+		if got := len(tc.msg.dataConflictErrors()); got != tc.conflicts {
+			t.Fatalf("#%d expect %d conflicts, got %d", i, tc.conflicts, got)
+		}
+	}
+}
+
+func TestValidateData(t *testing.T) {
+	cases := []struct {
+		data      map[string]interface{}
+		stringify bool
+		success   bool
+	}{
+		{nil, false, true},
+		{map[string]interface{}{"key": "value"}, false, true},
+		{map[string]interface{}{"count": 1}, false, false},
+		{map[string]interface{}{"count": 1, "ok": true}, true, true},
+		{map[string]interface{}{"nested": map[string]interface{}{"key": "value"}}, true, false},
+		{map[string]interface{}{"list": []string{"a"}}, true, false},
+	}
+
+	for i, tc := range cases {
+		errs := dataErrors(tc.data, tc.stringify)
+		if (len(errs) == 0) != tc.success {
+			t.Fatalf("#%d expect success to be %v, got %v", i, tc.success, errs)
+		}
+	}
+
+	data := newData(map[string]interface{}{"key": "value", "count": 1, "ok": true})
+	if data["key"] != "value" || data["count"] != "1" || data["ok"] != "true" {
+		t.Fatalf("unexpected stringified data: %v", data)
+	}
+}
+
+func TestLocalizedNotification(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.SetLocalizedTitle("Greeting", "greeting_title")
+	msg.SetLocalizedBody("Hello", "greeting_body", "Alice")
+
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	for _, want := range []string{
+		`"notification":{"title":"Greeting","body":"Hello"}`,
+		`"title_loc_key":"greeting_title"`,
+		`"body_loc_key":"greeting_body","body_loc_args":["Alice"]`,
+		`"alert":{"title":"Greeting","body":"Hello","title-loc-key":"greeting_title","loc-key":"greeting_body","loc-args":["Alice"]}`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("expect %s in the message, got %s", want, b)
+		}
+	}
+	if err := msg.validate(); err != nil {
+		t.Fatalf("expect the localized message to be valid: %v", err)
+	}
+
+	msg.Android.Notification.TitleLocKey = ""
+	msg.Android.Notification.TitleLocArgs = []string{"Alice"}
+	var verr *ValidationError
+	if err := msg.validate(); !errors.As(err, &verr) || verr.Field != "Android.Notification.TitleLocArgs" {
+		t.Fatalf("expect loc args without a loc key to be invalid, got %v", err)
+	}
+}
+
+func TestMessageTargets(t *testing.T) {
+	cases := []struct {
+		msg   *Message
+		field string
+	}{
+		{&Message{RegistrationIDs: []string{"1"}}, ""},
+		{&Message{Topic: "news"}, ""},
+		{&Message{Topic: "/topics/news"}, ""},
+		{&Message{Condition: "'news' in topics"}, ""},
+		{&Message{}, "RegistrationIDs"},
+		{&Message{RegistrationIDs: []string{}}, "RegistrationIDs"},
+		{&Message{RegistrationIDs: []string{"1"}, Topic: "news"}, "RegistrationIDs, Topic"},
+		{&Message{Topic: "news", Condition: "'news' in topics"}, "Topic, Condition"},
+		{&Message{Topic: "breaking news"}, "Topic"},
+	}
+
+	for i, tc := range cases {
+		err := tc.msg.validate()
+		if tc.field == "" {
+			if err != nil {
+				t.Fatalf("#%d expect the message to be valid: %v", i, err)
+			}
+			continue
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) || verr.Field != tc.field {
+			t.Fatalf("#%d expect an error for %s, got %v", i, tc.field, err)
+		}
+	}
+
+	v1 := newMessageV1(&Message{Topic: "/topics/news"}, "", time.Now())
+	if v1.Topic != "news" || v1.Token != "" {
+		t.Fatalf("expect the message to target the topic, got %+v", v1)
+	}
+}
+
+func TestSetDirectBootOk(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if strings.Contains(string(b), "direct_boot_ok") {
+		t.Fatalf("expect no direct_boot_ok by default, got %s", b)
+	}
+
+	msg.SetDirectBootOk(true)
+	b, err = json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"android":{"direct_boot_ok":true}`) {
+		t.Fatalf("expect direct_boot_ok in the android block, got %s", b)
+	}
+}
+
+func TestEventTimeAndSticky(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.SetSticky(true)
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"notification":{"sticky":true}`) {
+		t.Fatalf("expect sticky without an event time, got %s", b)
+	}
+
+	msg.SetEventTime(time.Date(2020, 9, 13, 21, 26, 40, 500000000, time.FixedZone("JST", 9*60*60)))
+	if err := msg.validate(); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	b, err = json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"event_time":"2020-09-13T12:26:40.5Z"`) {
+		t.Fatalf("expect the event time in UTC, got %s", b)
+	}
+
+	msg.SetEventTime(time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (event time out of range)")
+	}
+}
+
+func TestSetVisibility(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.SetVisibility(VisibilitySecret)
+	if err := msg.validate(); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"notification":{"visibility":"SECRET"}`) {
+		t.Fatalf("expect the visibility, got %s", b)
+	}
+
+	msg.SetVisibility("secret")
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (unknown visibility)")
+	}
+}
+
+func TestSetTickerAndSubText(t *testing.T) {
+	msg, err := NewMessageBuilder().AddToken("1").WithTicker("New message from Alice").WithSubText("Chat").Build()
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"android":{"notification":{"ticker":"New message from Alice","sub_text":"Chat"}}`) {
+		t.Fatalf("expect the ticker and the sub text in the android notification, got %s", b)
+	}
+}
+
+func TestSetDefaultSound(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.SetDefaultSound(true)
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"notification":{"default_sound":true}`) {
+		t.Fatalf("expect the default sound, got %s", b)
+	}
+
+	msg.SetSound("chime")
+	b, err = json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if strings.Contains(string(b), "default_sound") || !strings.Contains(string(b), `"sound":"chime"`) {
+		t.Fatalf("expect the explicit sound to win, got %s", b)
+	}
+}
+
+func TestSetAPNSThreadIDAndCategory(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.SetAPNSThreadID("chat-42")
+	msg.SetAPNSCategory("MESSAGE_REPLY")
+	if err := msg.validate(); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"aps":{"thread-id":"chat-42","category":"MESSAGE_REPLY"}`) {
+		t.Fatalf("expect the thread-id and the category, got %s", b)
+	}
+
+	msg.SetAPNSCategory("")
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (empty category)")
+	}
+}
+
+func TestSetAPNSImage(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.SetAPNSImage("https://example.com/image.png")
+	if err := msg.validate(); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"fcm_options":{"image":"https://example.com/image.png"}`) || !strings.Contains(string(b), `"mutable-content":1`) {
+		t.Fatalf("expect the image in the apns fcm_options with mutable-content, got %s", b)
+	}
+
+	if err := msg.SetAPNSRawPayload(map[string]interface{}{"custom": true}); err != nil {
+		t.Fatalf("failed to set the raw payload: %v", err)
+	}
+	b, err = json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"fcm_options":{"image":"https://example.com/image.png"}`) {
+		t.Fatalf("expect the image with a raw payload, got %s", b)
+	}
+
+	msg.SetAPNSImage("http://example.com/image.png")
+	if err := msg.validate(); err == nil {
+		t.Fatalf("expect to be failed (not https)")
+	}
+}
+
+func TestAnalyticsLabels(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.AnalyticsLabel = "campaign_mobile"
+	msg.SetWebpushAnalyticsLabel("campaign_web")
+	if err := msg.validate(); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	v1 := newMessageV1(msg, "1", time.Now())
+	if v1.FCMOptions.AnalyticsLabel != "campaign_mobile" || v1.Webpush.FCMOptions.AnalyticsLabel != "campaign_web" {
+		t.Fatalf("expect separate analytics labels, got %+v and %+v", v1.FCMOptions, v1.Webpush.FCMOptions)
+	}
+
+	msg.SetWebpushAnalyticsLabel("campaign web")
+	var verr *ValidationError
+	if err := msg.validate(); !errors.As(err, &verr) || verr.Field != "Webpush.FCMOptions.AnalyticsLabel" {
+		t.Fatalf("expect the web push label to be invalid, got %v", err)
+	}
+}
+
+func TestAPNSPriorityFromPriority(t *testing.T) {
+	cases := []struct {
+		priority Priority
+		silent   bool
+		headers  map[string]string
+		want     string
+	}{
+		{"", false, nil, ""},
+		{"high", false, nil, apnsPriorityHigh},
+		{"normal", false, nil, apnsPriorityLow},
+		{"high", true, nil, apnsPriorityLow},
+		{"high", false, map[string]string{apnsPriorityHeader: "5"}, "5"},
+	}
+
+	for i, tc := range cases {
+		msg := NewMessage(nil, "1")
+		msg.Priority = tc.priority
+		if tc.silent {
+			msg.SetContentAvailable(true)
+		}
+		if tc.headers != nil {
+			msg.APNS = &APNS{Headers: tc.headers}
+		}
+
+		var got string
+		if apns := newMessageV1(msg, "1", time.Now()).APNS; apns != nil {
+			got = apns.Headers[apnsPriorityHeader]
+		}
+		if got != tc.want {
+			t.Fatalf("#%d expect apns-priority %q, got %q", i, tc.want, got)
+		}
+	}
+}
+
+func TestPriorityJSON(t *testing.T) {
+	msg := NewMessage(nil, "1")
+	msg.Priority = PriorityHigh
+	b, err := json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"android":{"priority":"HIGH"}`) {
+		t.Fatalf("expect the upper case android priority, got %s", b)
+	}
+
+	msg.Android = &Android{Priority: PriorityNormal}
+	msg.Priority = ""
+	b, err = json.Marshal(newMessageV1(msg, "1", time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal the message: %v", err)
+	}
+	if !strings.Contains(string(b), `"android":{"priority":"NORMAL"}`) {
+		t.Fatalf("expect the upper case android priority, got %s", b)
+	}
+}
+
+func TestClone(t *testing.T) {
+	msg := NewMessage(map[string]interface{}{"key": "value"}, "1")
+	msg.SetLocalizedBody("body", "BODY_KEY", "arg")
+	msg.SetNotificationCount(1)
+	msg.SetBadge(1)
+	msg.SetAPNSPushType("alert")
+	msg.SetWebpushAnalyticsLabel("label")
+	clone := msg.Clone()
+	if !reflect.DeepEqual(clone, msg) {
+		t.Fatalf("expect the clone to equal the message, got %+v", clone)
+	}
+
+	clone.RegistrationIDs[0] = "2"
+	clone.Data["key"] = "other"
+	clone.Android.Notification.BodyLocArgs[0] = "other"
+	*clone.Android.Notification.NotificationCount = 2
+	clone.APNS.Payload.Aps.Alert.LocArgs[0] = "other"
+	*clone.APNS.Payload.Aps.Badge = 2
+	clone.APNS.Headers[apnsPushTypeHeader] = "background"
+	clone.Webpush.FCMOptions.AnalyticsLabel = "other"
+
+	if msg.RegistrationIDs[0] != "1" || msg.Data["key"] != "value" ||
+		msg.Android.Notification.BodyLocArgs[0] != "arg" || *msg.Android.Notification.NotificationCount != 1 ||
+		msg.APNS.Payload.Aps.Alert.LocArgs[0] != "arg" || *msg.APNS.Payload.Aps.Badge != 1 ||
+		msg.APNS.Headers[apnsPushTypeHeader] != "alert" || msg.Webpush.FCMOptions.AnalyticsLabel != "label" {
+		t.Fatalf("expect the message to be unmodified by its clone, got %+v", msg)
+	}
+}
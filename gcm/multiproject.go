@@ -0,0 +1,106 @@
+package gcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MultiProjectSender sends a message to registration tokens belonging to
+// different Firebase projects. Each project is served by its own Client and
+// credentials, and the tokens of a message are routed to the project they
+// were issued for.
+type MultiProjectSender struct {
+	projects map[string]projectSender
+}
+
+type projectSender struct {
+	client      *Client
+	acsJsonData []byte
+}
+
+// NewMultiProjectSender returns a sender without projects. Add the projects
+// with Add before sending.
+func NewMultiProjectSender() *MultiProjectSender {
+	return &MultiProjectSender{projects: map[string]projectSender{}}
+}
+
+// Add registers the client and the service account JSON of a project. A
+// project added twice is replaced.
+func (s *MultiProjectSender) Add(projectID string, client *Client, acsJsonData []byte) {
+	s.projects[projectID] = projectSender{client: client, acsJsonData: acsJsonData}
+}
+
+// Send sends msg to its registration IDs, each through the client of the
+// project projectOf maps it to. The message is rejected before anything is
+// sent if a token maps to no added project.
+//
+// The returned Response holds the Results of all tokens in the order of
+// msg.RegistrationIDs. A failed token gets a Result with its error and the
+// other tokens of its project are still sent, as with Client.SendEach. When
+// sending to a project fails as a whole, e.g. as the access token can not be
+// fetched, the Results of all its tokens carry the error. The errors are
+// also returned, joined, along with the Response.
+func (s *MultiProjectSender) Send(msg *Message, projectOf map[string]string, opts ...SendOption) (*Response, error) {
+	if err := msg.validate(); err != nil {
+		return nil, err
+	}
+
+	indices := map[string][]int{}
+	for i, token := range msg.RegistrationIDs {
+		projectID, ok := projectOf[token]
+		if !ok {
+			return nil, fmt.Errorf("no project is mapped to the registration token %q", token)
+		}
+		if _, ok := s.projects[projectID]; !ok {
+			return nil, fmt.Errorf("unknown project %q of the registration token %q", projectID, token)
+		}
+		indices[projectID] = append(indices[projectID], i)
+	}
+
+	projectIDs := make([]string, 0, len(indices))
+	for projectID := range indices {
+		projectIDs = append(projectIDs, projectID)
+	}
+	sort.Strings(projectIDs)
+
+	response := &Response{Results: make([]Result, len(msg.RegistrationIDs)), Validated: newSendOptions(opts).validateOnly(msg)}
+	var errs []error
+	for _, projectID := range projectIDs {
+		projectMsg := *msg
+		projectMsg.RegistrationIDs = make([]string, 0, len(indices[projectID]))
+		for _, i := range indices[projectID] {
+			projectMsg.RegistrationIDs = append(projectMsg.RegistrationIDs, msg.RegistrationIDs[i])
+		}
+
+		project := s.projects[projectID]
+		sent := project.client.normalizeTokens(&projectMsg)
+		err := project.client.validate(sent)
+		var resp *Response
+		if err == nil {
+			resp, err = project.client.sendEach(context.Background(), sent, project.acsJsonData, newSendOptions(opts))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("project %s: %w", projectID, err))
+		}
+		if resp == nil {
+			for _, i := range indices[projectID] {
+				response.Results[i] = newErrorResult(msg.RegistrationIDs[i], err)
+				response.Results[i].CorrelationID = msg.CorrelationID
+				response.Results[i].Meta = msg.Meta
+			}
+			response.FailureCount += len(indices[projectID])
+			continue
+		}
+
+		for j, i := range indices[projectID] {
+			response.Results[i] = resp.Results[j]
+		}
+		response.FailureCount += resp.FailureCount
+		response.InvalidTokens = append(response.InvalidTokens, resp.InvalidTokens...)
+		response.SenderIDMismatchTokens = append(response.SenderIDMismatchTokens, resp.SenderIDMismatchTokens...)
+	}
+
+	return response, errors.Join(errs...)
+}
@@ -0,0 +1,109 @@
+package gcm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMultiProjectSender(t *testing.T) {
+	newProjectServer := func(projectID string, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/token" {
+				serveTestToken(w)
+				return
+			}
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+
+			var received WrappedMessage
+			if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+				t.Errorf("invalid request body: %s", err)
+				return
+			}
+			if received.Message.Token == "bad" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if received.Message.Token == "mismatch" {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"error":{"code":403,"status":"PERMISSION_DENIED","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"SENDER_ID_MISMATCH"}]}}`)
+				return
+			}
+			fmt.Fprintf(w, `{"name":"projects/%s/messages/%s"}`, projectID, received.Message.Token)
+		}))
+	}
+
+	sender := NewMultiProjectSender()
+	for _, p := range []struct {
+		id     string
+		status int
+	}{
+		{"alpha", http.StatusOK},
+		{"beta", http.StatusOK},
+		{"broken", http.StatusInternalServerError},
+	} {
+		server := newProjectServer(p.id, p.status)
+		defer server.Close()
+
+		client, err := NewClient(server.URL, "testAPIKey")
+		if err != nil {
+			t.Fatalf("Failed to setup sender client: %s", err)
+		}
+		client.PruneUnregistered = true
+		sender.Add(p.id, client, testCredentials(t, server.URL+"/token"))
+	}
+
+	projectOf := map[string]string{"1": "alpha", "2": "beta", "3": "alpha"}
+	resp, err := sender.Send(NewMessage(nil, "1", "2", "3"), projectOf)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	for i, want := range []string{"projects/alpha/messages/1", "projects/beta/messages/2", "projects/alpha/messages/3"} {
+		if resp.Results[i].MessageID != want {
+			t.Fatalf("#%d expect message ID %s, got %+v", i, want, resp.Results[i])
+		}
+	}
+
+	projectOf["4"] = "broken"
+	resp, err = sender.Send(NewMessage(nil, "1", "4"), projectOf)
+	if err == nil {
+		t.Fatalf("expect to be failed (project broken)")
+	}
+	if resp.Results[0].MessageID != "projects/alpha/messages/1" || resp.Results[1].Error == "" || resp.FailureCount != 1 {
+		t.Fatalf("expect the results of the other projects to be kept, got %+v", resp.Results)
+	}
+
+	projectOf["bad"], projectOf["6"] = "beta", "beta"
+	resp, err = sender.Send(NewMessage(nil, "2", "bad", "6"), projectOf)
+	var fcmErr *FCMError
+	if !errors.As(err, &fcmErr) || fcmErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expect the error of the bad token, got %v", err)
+	}
+	if resp.FailureCount != 1 || resp.Results[1].Error == "" || resp.Results[0].MessageID != "projects/beta/messages/2" || resp.Results[2].MessageID != "projects/beta/messages/6" {
+		t.Fatalf("expect only the bad token to fail, got %+v", resp.Results)
+	}
+
+	projectOf["mismatch"] = "beta"
+	resp, err = sender.Send(NewMessage(nil, "1", "mismatch"), projectOf)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if len(resp.SenderIDMismatchTokens) != 1 || resp.SenderIDMismatchTokens[0] != "mismatch" {
+		t.Fatalf("expect the SENDER_ID_MISMATCH token of project beta, got %v", resp.SenderIDMismatchTokens)
+	}
+
+	if _, err := sender.Send(NewMessage(nil, "unmapped"), projectOf); err == nil {
+		t.Fatalf("expect to be failed (unmapped token)")
+	}
+
+	projectOf["5"] = "unknown"
+	if _, err := sender.Send(NewMessage(nil, "5"), projectOf); err == nil {
+		t.Fatalf("expect to be failed (unknown project)")
+	}
+}
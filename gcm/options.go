@@ -0,0 +1,196 @@
+package gcm
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// SendOption configures a single send. Options are applied in order, so a
+// later option overrides an earlier one.
+type SendOption func(*sendOptions)
+
+// sendOptions are the settings of a single send.
+type sendOptions struct {
+	headers      map[string]string
+	dryRun       *bool
+	autoCollapse bool
+	endpoint     string
+	credentials  []byte
+	httpClient   *http.Client
+
+	// onRequestBody is called with the body of each request, see
+	// WithRequestBody.
+	onRequestBody func([]byte)
+
+	// onResponse is called with each response of the send, see
+	// Client.SendTest.
+	onResponse func(resp *http.Response, body []byte)
+
+	// onProgress is called as Client.SendToAll goes, see WithProgress.
+	onProgress func(done, total int)
+
+	// pruneInvalid makes the invalid tokens results instead of errors, see
+	// Client.SendAndPrune.
+	pruneInvalid bool
+
+	// onTokenError makes a failed token a result instead of failing the
+	// send, and is called with its error, see Client.SendEach.
+	onTokenError func(token string, err error)
+}
+
+func newSendOptions(opts []SendOption) *sendOptions {
+	o := &sendOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithHeaders adds HTTP headers to the requests of the send, e.g. tracing
+// headers like X-Cloud-Trace-Context. The Authorization header can not be
+// set; a send with it fails.
+func WithHeaders(headers map[string]string) SendOption {
+	return func(o *sendOptions) {
+		if o.headers == nil {
+			o.headers = make(map[string]string, len(headers))
+		}
+		for k, v := range headers {
+			o.headers[k] = v
+		}
+	}
+}
+
+// WithDryRun overrides Message.DryRun for the send: with true FCM only
+// validates the message (validate_only), with false it is delivered.
+func WithDryRun(dryRun bool) SendOption {
+	return func(o *sendOptions) {
+		o.dryRun = &dryRun
+	}
+}
+
+// WithAutoCollapse makes a message without a collapse key collapse with the
+// identical messages sent before: the collapse key is generated from a hash
+// of the notification title and body and the data. It is also used as
+// apns-collapse-id if the message is SetAPNSCollapseFromCollapseKey.
+func WithAutoCollapse(autoCollapse bool) SendOption {
+	return func(o *sendOptions) {
+		o.autoCollapse = autoCollapse
+	}
+}
+
+// WithEndpoint sends the message to the given FCM send endpoint instead of
+// Client.URL, reusing the HTTP client. The credentials must belong to the
+// project of the endpoint, see WithCredentials.
+func WithEndpoint(urlString string) SendOption {
+	return func(o *sendOptions) {
+		o.endpoint = urlString
+	}
+}
+
+// WithProject sends the message to the FCM send endpoint of the given
+// Firebase project, see WithEndpoint.
+func WithProject(projectID string) SendOption {
+	return WithEndpoint(MakeFCMSendEndpoint(projectID))
+}
+
+// WithCredentials overrides the service account JSON passed to the send,
+// e.g. with the credentials of the project given by WithProject.
+func WithCredentials(acsJsonData []byte) SendOption {
+	return func(o *sendOptions) {
+		o.credentials = acsJsonData
+	}
+}
+
+// WithHTTPClient posts the requests of the send with h instead of the Http
+// client of the Client, e.g. to route a tenant through another proxy. The
+// access token is still fetched, and cached, with the Http client.
+func WithHTTPClient(h *http.Client) SendOption {
+	return func(o *sendOptions) {
+		o.httpClient = h
+	}
+}
+
+// WithRequestBody calls fn with the JSON body of each request of the send
+// before it is posted, e.g. to log the exact payload. The body is the one
+// Client.Marshal returns; fn must not modify it or keep it once it returns.
+func WithRequestBody(fn func(body []byte)) SendOption {
+	return func(o *sendOptions) {
+		o.onRequestBody = fn
+	}
+}
+
+// WithProgress calls fn each time Client.SendToAll finishes a chunk of
+// tokens, with the number of tokens done so far, including the failed and
+// dropped ones, and the total number of tokens. The calls are not
+// concurrent and done increases with each of them.
+func WithProgress(fn func(done, total int)) SendOption {
+	return func(o *sendOptions) {
+		o.onProgress = fn
+	}
+}
+
+// prepare returns msg as it is sent with o: with WithAutoCollapse and no
+// collapse key, a copy of msg with the derived collapse key.
+func (o *sendOptions) prepare(msg *Message) *Message {
+	if !o.autoCollapse || msg.CollapseKey != "" || (msg.Android != nil && msg.Android.CollapseKey != "") {
+		return msg
+	}
+	collapsed := *msg
+	collapsed.CollapseKey = autoCollapseKey(msg)
+	return &collapsed
+}
+
+// http returns the HTTP client the send posts with.
+func (o *sendOptions) http(c *Client) *http.Client {
+	if o.httpClient != nil {
+		return o.httpClient
+	}
+	return c.Http
+}
+
+// url returns the endpoint the send posts to.
+func (o *sendOptions) url(c *Client) string {
+	if o.endpoint != "" {
+		return o.endpoint
+	}
+	return c.URL
+}
+
+// acsJsonData returns the service account JSON of the send.
+func (o *sendOptions) acsJsonData(acsJsonData []byte) []byte {
+	if o.credentials != nil {
+		return o.credentials
+	}
+	return acsJsonData
+}
+
+// validateOnly reports whether msg is sent as a dry run.
+func (o *sendOptions) validateOnly(msg *Message) bool {
+	if o.dryRun != nil {
+		return *o.dryRun
+	}
+	return msg.DryRun
+}
+
+func (o *sendOptions) validate() error {
+	if o.endpoint != "" {
+		if _, err := url.Parse(o.endpoint); err != nil {
+			return fmt.Errorf("failed to parse URL %q: %s", o.endpoint, err)
+		}
+	}
+
+	for k := range o.headers {
+		if http.CanonicalHeaderKey(k) == "Authorization" {
+			return fmt.Errorf("the Authorization header can not be overridden")
+		}
+	}
+	return nil
+}
+
+// apply sets the headers of the send on req.
+func (o *sendOptions) apply(req *http.Request) {
+	for k, v := range o.headers {
+		req.Header.Set(k, v)
+	}
+}
@@ -0,0 +1,264 @@
+package gcm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithHeaders(t *testing.T) {
+	var header http.Header
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		header = r.Header
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	trace := WithHeaders(map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/1;o=1"})
+	if _, err := sender.Send(NewMessage(nil, "1"), creds, trace); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if got := header.Get("X-Cloud-Trace-Context"); got != "105445aa7843bc8bf206b12000100000/1;o=1" {
+		t.Fatalf("expect the trace header to be sent, got %q", got)
+	}
+	if got := header.Get("Authorization"); got != "Bearer test-access-token" {
+		t.Fatalf("expect the Authorization header to be kept, got %q", got)
+	}
+
+	override := WithHeaders(map[string]string{"authorization": "Bearer other"})
+	if _, err := sender.Send(NewMessage(nil, "1"), creds, override); err == nil {
+		t.Fatalf("expect to be failed (Authorization header)")
+	}
+}
+
+func TestWithDryRun(t *testing.T) {
+	var validateOnly bool
+	var trace string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		validateOnly = received.ValidateOnly
+		trace = r.Header.Get("X-Trace")
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	resp, err := sender.Send(NewMessage(nil, "1"), creds, WithDryRun(true), WithHeaders(map[string]string{"X-Trace": "1"}))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if !validateOnly || !resp.Validated || trace != "1" {
+		t.Fatalf("expect a validate_only send with the header, got validate_only %v, validated %v, header %q", validateOnly, resp.Validated, trace)
+	}
+
+	msg := NewMessage(nil, "1")
+	msg.DryRun = true
+	resp, err = sender.Send(msg, creds, WithDryRun(false))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if validateOnly || resp.Validated {
+		t.Fatalf("expect WithDryRun(false) to override Message.DryRun")
+	}
+}
+
+func TestWithAutoCollapse(t *testing.T) {
+	var collapseKeys []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		collapseKeys = append(collapseKeys, received.Message.Android.CollapseKey)
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	newMsg := func(body string) *Message {
+		msg := NewMessage(map[string]interface{}{"event": "42"}, "1")
+		msg.Notification.Title = "title"
+		msg.Notification.Body = body
+		return msg
+	}
+	msgs := []*Message{newMsg("body"), newMsg("body"), newMsg("other")}
+	for _, msg := range msgs {
+		if _, err := sender.Send(msg, creds, WithAutoCollapse(true)); err != nil {
+			t.Fatalf("expect to be success: %v", err)
+		}
+	}
+	explicit := newMsg("body")
+	explicit.CollapseKey = "explicit"
+	if _, err := sender.Send(explicit, creds, WithAutoCollapse(true)); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	if collapseKeys[0] == "" || collapseKeys[0] != collapseKeys[1] {
+		t.Fatalf("expect identical messages to share a collapse key, got %v", collapseKeys)
+	}
+	if collapseKeys[0] == collapseKeys[2] {
+		t.Fatalf("expect different messages to have different collapse keys, got %v", collapseKeys)
+	}
+	if collapseKeys[3] != "explicit" {
+		t.Fatalf("expect an explicit collapse key to be kept, got %q", collapseKeys[3])
+	}
+	if msgs[0].CollapseKey != "" {
+		t.Fatalf("expect the messages not to be modified")
+	}
+}
+
+func TestWithEndpoint(t *testing.T) {
+	newServer := func(sends *int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/token" {
+				serveTestToken(w)
+				return
+			}
+			*sends++
+			fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+		}))
+	}
+	var defaultSends, tenantSends int
+	defaultServer := newServer(&defaultSends)
+	defer defaultServer.Close()
+	tenantServer := newServer(&tenantSends)
+	defer tenantServer.Close()
+
+	sender, err := NewClient(defaultServer.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	_, err = sender.Send(NewMessage(nil, "1"), testCredentials(t, defaultServer.URL+"/token"),
+		WithEndpoint(tenantServer.URL), WithCredentials(testCredentials(t, tenantServer.URL+"/token")))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if defaultSends != 0 || tenantSends != 1 {
+		t.Fatalf("expect the message to be sent to the overridden endpoint, got %d and %d sends", defaultSends, tenantSends)
+	}
+
+	if _, err := sender.Send(NewMessage(nil, "1"), nil, WithEndpoint("://invalid")); err == nil {
+		t.Fatalf("expect to be failed (invalid endpoint)")
+	}
+
+	if got := newSendOptions([]SendOption{WithProject("tenant")}).url(sender); got != MakeFCMSendEndpoint("tenant") {
+		t.Fatalf("expect the endpoint of the project, got %s", got)
+	}
+}
+
+func TestWithRequestBody(t *testing.T) {
+	var received []byte
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		received, _ = ioutil.ReadAll(r.Body)
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	msg := NewMessage(map[string]interface{}{"k": "v"}, "1")
+	msg.Notification.Title = "hello"
+	var bodies [][]byte
+	record := WithRequestBody(func(body []byte) {
+		bodies = append(bodies, append([]byte(nil), body...))
+	})
+	if _, err := sender.Send(msg, testCredentials(t, server.URL+"/token"), record, WithAutoCollapse(true)); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if len(bodies) != 1 || !bytes.Equal(bodies[0], received) {
+		t.Fatalf("expect the callback to get the sent body %s, got %q", received, bodies)
+	}
+
+	marshaled, err := sender.Marshal(msg, WithAutoCollapse(true))
+	if err != nil {
+		t.Fatalf("expect Marshal() to be success: %v", err)
+	}
+	if !bytes.Equal(marshaled, received) {
+		t.Fatalf("expect Marshal() to return the sent body %s, got %s", received, marshaled)
+	}
+
+	if _, err := sender.Marshal(NewMessage(nil)); err == nil {
+		t.Fatalf("expect Marshal() of an invalid message to be failed")
+	}
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	server := startTestServer(t, &testResponse{Response: &Response{}})
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	var proxied []string
+	h := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		proxied = append(proxied, req.URL.Path)
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	if _, err := sender.Send(NewMessage(nil, "1", "2"), creds, WithHTTPClient(h)); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if len(proxied) != 2 {
+		t.Fatalf("expect the sends through the given client, got %v", proxied)
+	}
+
+	if _, err := sender.Send(NewMessage(nil, "1"), creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if len(proxied) != 2 {
+		t.Fatalf("expect the other sends through the Http client, got %v", proxied)
+	}
+}
@@ -0,0 +1,74 @@
+package gcm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PersonalizedMessage is a registration token with the overrides of the
+// message sent to it, see Client.SendPersonalized.
+type PersonalizedMessage struct {
+	Token string
+	// Data is merged into the Data of the message, its keys winning.
+	Data map[string]interface{}
+	// Notification, if not nil, replaces the Notification of the message.
+	Notification *Notification
+	// Meta, if not nil, replaces the Meta of the message, so that each
+	// Result can be matched back to its item.
+	Meta interface{}
+}
+
+// SendPersonalized sends msg to the token of each of items, with the data
+// and the notification of the item, e.g. a deep link with the context of
+// the device. The RegistrationIDs of msg are ignored. The messages are sent
+// concurrently by StreamWorkers workers like with SendToAll, sharing the
+// access token, the rate limit and the retries of the client, and every
+// message is validated before anything is sent.
+//
+// The returned Response has a Result for each item in the order of items,
+// and the returned error tells the outcome as with SendToAll.
+func (c *Client) SendPersonalized(msg *Message, items []PersonalizedMessage, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	if msg == nil {
+		return nil, &ValidationError{Field: "Message", Reason: "the message must not be nil"}
+	}
+	if len(items) == 0 {
+		return nil, &ValidationError{Field: "items", Reason: "must not be empty"}
+	}
+
+	msgs := make([]*Message, len(items))
+	for i, item := range items {
+		personalized := c.normalizeTokens(item.apply(msg))
+		if err := c.validate(personalized); err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		msgs[i] = personalized
+	}
+
+	o := newSendOptions(opts)
+	responses, errs := c.sendConcurrently(msgs, acsJsonData, o, nil)
+
+	response := mergeResponses(msgs, responses, errs)
+	response.Validated = o.validateOnly(msg)
+	return response, response.err(errors.Join(errs...))
+}
+
+// apply returns a copy of msg sent to the token of p with its overrides.
+func (p PersonalizedMessage) apply(msg *Message) *Message {
+	personalized := msg.Clone()
+	personalized.RegistrationIDs = []string{p.Token}
+	if len(p.Data) > 0 {
+		if personalized.Data == nil {
+			personalized.Data = make(map[string]interface{}, len(p.Data))
+		}
+		for k, v := range p.Data {
+			personalized.Data[k] = v
+		}
+	}
+	if p.Notification != nil {
+		personalized.Notification = *p.Notification
+	}
+	if p.Meta != nil {
+		personalized.Meta = p.Meta
+	}
+	return personalized
+}
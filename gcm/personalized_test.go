@@ -0,0 +1,71 @@
+package gcm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendPersonalized(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		data := received.Message.Data
+		if data["campaign"] != "spring" || data["link"] != "app://item/"+received.Message.Token {
+			t.Errorf("unexpected data for token %s: %v", received.Message.Token, data)
+			return
+		}
+		title := received.Message.Notification.Title
+		if (received.Message.Token == "2") != (title == "For you") {
+			t.Errorf("unexpected title for token %s: %q", received.Message.Token, title)
+			return
+		}
+		fmt.Fprintf(w, `{"name":"projects/test/messages/%s"}`, received.Message.Token)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.StreamWorkers = 2
+	creds := testCredentials(t, server.URL+"/token")
+
+	msg := NewMessage(map[string]interface{}{"campaign": "spring", "link": "app://home"})
+	msg.Notification = Notification{Title: "Sale", Body: "Up to 50% off"}
+	items := []PersonalizedMessage{
+		{Token: "1", Data: map[string]interface{}{"link": "app://item/1"}, Meta: 0},
+		{Token: "2", Data: map[string]interface{}{"link": "app://item/2"}, Notification: &Notification{Title: "For you", Body: "Your item is on sale"}, Meta: 1},
+		{Token: "3", Data: map[string]interface{}{"link": "app://item/3"}, Meta: 2},
+	}
+	resp, err := sender.SendPersonalized(msg, items, creds)
+	if err != nil {
+		t.Fatalf("expect all messages to be sent, got %v", err)
+	}
+	if len(resp.Results) != len(items) {
+		t.Fatalf("expect %d results, got %d", len(items), len(resp.Results))
+	}
+	for i, result := range resp.Results {
+		if result.Token != items[i].Token || result.MessageID != "projects/test/messages/"+items[i].Token || result.Meta != i {
+			t.Fatalf("expect the result of token %s at %d, got %+v", items[i].Token, i, result)
+		}
+	}
+	if msg.Data["link"] != "app://home" || len(msg.RegistrationIDs) != 0 {
+		t.Fatalf("expect the message not to be modified, got %+v", msg)
+	}
+
+	if _, err := sender.SendPersonalized(msg, nil, creds); err == nil {
+		t.Fatalf("expect no items to fail")
+	}
+}
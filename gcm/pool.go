@@ -0,0 +1,122 @@
+package gcm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// maxPooledBufferSize is the max capacity of a buffer put back to
+// bufferPool, so that a single large message does not keep its memory.
+const maxPooledBufferSize = 64 << 10
+
+// bufferPool holds the buffers the request bodies are encoded and
+// compressed into, each with a JSON encoder writing to it.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := &pooledBuffer{}
+		b.enc = json.NewEncoder(&b.Buffer)
+		return b
+	},
+}
+
+// gzipWriterPool holds the gzip writers compressing the request bodies.
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(ioutil.Discard)
+	},
+}
+
+// pooledBuffer is a buffer of bufferPool shared by a send and the request
+// bodies read from it. A transport may read and close a request body after
+// Do returns, so the buffer is only put back to the pool when the send
+// released it and every body read from it was closed. The buffer of a body
+// that is never closed is left to the garbage collector.
+type pooledBuffer struct {
+	bytes.Buffer
+	enc *json.Encoder
+
+	mu   sync.Mutex
+	refs int
+}
+
+// getBuffer returns an empty buffer of bufferPool, which the caller must
+// release.
+func getBuffer() *pooledBuffer {
+	b := bufferPool.Get().(*pooledBuffer)
+	b.refs = 1
+	return b
+}
+
+// encode writes the JSON encoding of v to b, like json.Marshal.
+func (b *pooledBuffer) encode(v interface{}) error {
+	if err := b.enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode terminates the value with a newline json.Marshal does not add.
+	b.Truncate(b.Len() - 1)
+	return nil
+}
+
+func (b *pooledBuffer) retain() {
+	b.mu.Lock()
+	b.refs++
+	b.mu.Unlock()
+}
+
+// release drops a reference to b and puts b back to the pool once no
+// reference is left.
+func (b *pooledBuffer) release() {
+	b.mu.Lock()
+	b.refs--
+	done := b.refs == 0
+	b.mu.Unlock()
+
+	if done && b.Cap() <= maxPooledBufferSize {
+		b.Reset()
+		bufferPool.Put(b)
+	}
+}
+
+// newBody returns a request body reading the contents of b, which holds a
+// reference to b until it is closed.
+func (b *pooledBuffer) newBody() io.ReadCloser {
+	b.retain()
+	return &pooledBody{Reader: bytes.NewReader(b.Bytes()), buf: b}
+}
+
+// pooledBody is a request body reading a pooledBuffer.
+type pooledBody struct {
+	*bytes.Reader
+	buf  *pooledBuffer
+	once sync.Once
+}
+
+func (b *pooledBody) Close() error {
+	b.once.Do(b.buf.release)
+	return nil
+}
+
+// gzipBuffer compresses the contents of b into a new buffer of bufferPool.
+func gzipBuffer(b *pooledBuffer) (*pooledBuffer, error) {
+	compressed := getBuffer()
+	gw := gzipWriterPool.Get().(*gzip.Writer)
+	defer func() {
+		gw.Reset(ioutil.Discard)
+		gzipWriterPool.Put(gw)
+	}()
+
+	gw.Reset(compressed)
+	if _, err := gw.Write(b.Bytes()); err != nil {
+		compressed.release()
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		compressed.release()
+		return nil, err
+	}
+	return compressed, nil
+}
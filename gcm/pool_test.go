@@ -0,0 +1,133 @@
+package gcm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestGzipServer starts a server checking that each compressed request
+// body decodes to its own token.
+func startTestGzipServer(tb testing.TB) *httptest.Server {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			tb.Errorf("invalid gzip body: %s", err)
+			return
+		}
+		var received WrappedMessage
+		if err := json.NewDecoder(gr).Decode(&received); err != nil {
+			tb.Errorf("invalid request body: %s", err)
+			return
+		}
+		if received.Message.Data["token"] != received.Message.Token {
+			tb.Errorf("expect the body of token %s, got the data of %s", received.Message.Token, received.Message.Data["token"])
+			return
+		}
+		fmt.Fprintf(w, `{"name":"projects/test/messages/%s"}`, received.Message.Token)
+	}
+	return httptest.NewServer(http.HandlerFunc(handler))
+}
+
+func TestPooledBuffersConcurrently(t *testing.T) {
+	server := startTestGzipServer(t)
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.GzipThreshold = 1
+	sender.StreamWorkers = 8
+	creds := testCredentials(t, server.URL+"/token")
+
+	in := make(chan *Message)
+	go func() {
+		defer close(in)
+		for i := 0; i < 200; i++ {
+			token := strconv.Itoa(i)
+			in <- NewMessage(map[string]interface{}{"token": token, "pad": strings.Repeat(token, i)}, token)
+		}
+	}()
+
+	n := 0
+	for result := range sender.SendStream(context.Background(), in, creds) {
+		if result.Error != "" || result.MessageID != "projects/test/messages/"+result.Token {
+			t.Fatalf("expect the message of token %s to be sent, got %+v", result.Token, result)
+		}
+		n++
+	}
+	if n != 200 {
+		t.Fatalf("expect 200 results, got %d", n)
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	payload := getBuffer()
+	defer payload.release()
+	if err := payload.encode(newMessageV1(NewMessage(map[string]interface{}{"key": strings.Repeat("x", 2048)}, "1"), "1", time.Now())); err != nil {
+		b.Fatalf("failed to encode the message: %v", err)
+	}
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			compressed, err := gzipBuffer(payload)
+			if err != nil {
+				b.Fatal(err)
+			}
+			compressed.release()
+		}
+	})
+
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var compressed bytes.Buffer
+			gw := gzip.NewWriter(&compressed)
+			if _, err := gw.Write(payload.Bytes()); err != nil {
+				b.Fatal(err)
+			}
+			if err := gw.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSendCompressed(b *testing.B) {
+	server := startTestGzipServer(b)
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		b.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.GzipThreshold = 1
+	creds := testCredentials(b, server.URL+"/token")
+	msg := NewMessage(map[string]interface{}{"token": "1", "pad": strings.Repeat("x", 2048)}, "1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := sender.Send(msg, creds); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package gcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// TokenStore is the storage of registration tokens the application sends to,
+// from which SendAndPrune removes the tokens FCM rejects.
+type TokenStore interface {
+	Remove(token string) error
+}
+
+// SendAndPrune sends msg like Send and removes the registration tokens FCM
+// reports as UNREGISTERED or SENDER_ID_MISMATCH, or as an INVALID_ARGUMENT
+// because the token is malformed, from store. These tokens get a Result
+// with the error code and are listed in Response.InvalidTokens instead of
+// failing the send. The other errors fail the send as with Send. The errors
+// of store.Remove are joined and returned with the Response.
+func (c *Client) SendAndPrune(msg *Message, store TokenStore, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	msg = c.normalizeTokens(msg)
+	if err := c.validate(msg); err != nil {
+		return nil, err
+	}
+
+	o := newSendOptions(opts)
+	o.pruneInvalid = true
+	response, err := c.send(context.Background(), msg, acsJsonData, o)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, token := range response.InvalidTokens {
+		if err := store.Remove(token); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove the token %s: %w", token, err))
+		}
+	}
+
+	return response, errors.Join(errs...)
+}
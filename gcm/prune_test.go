@@ -0,0 +1,94 @@
+package gcm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type memoryTokenStore struct {
+	removed []string
+}
+
+func (s *memoryTokenStore) Remove(token string) error {
+	s.removed = append(s.removed, token)
+	return nil
+}
+
+func TestSendAndPrune(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		switch received.Message.Token {
+		case "gone":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`)
+		case "malformed":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"code":400,"status":"INVALID_ARGUMENT","message":"The registration token is not a valid FCM registration token","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"}]}}`)
+		case "other-project":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"code":403,"status":"PERMISSION_DENIED","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"SENDER_ID_MISMATCH"}]}}`)
+		case "bad-message":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"code":400,"status":"INVALID_ARGUMENT","message":"Invalid value at 'message.android.ttl'","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"}]}}`)
+		default:
+			fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	store := &memoryTokenStore{}
+	resp, err := sender.SendAndPrune(NewMessage(nil, "1", "gone", "malformed"), store, creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if want := []string{"gone", "malformed"}; !reflect.DeepEqual(store.removed, want) || !reflect.DeepEqual(resp.InvalidTokens, want) {
+		t.Fatalf("expect %v to be pruned, got %v removed and %v invalid", want, store.removed, resp.InvalidTokens)
+	}
+	if resp.FailureCount != 0 || len(resp.Results) != 3 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	store = &memoryTokenStore{}
+	resp, err = sender.SendAndPrune(NewMessage(nil, "1", "other-project"), store, creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if want := []string{"other-project"}; !reflect.DeepEqual(store.removed, want) || !reflect.DeepEqual(resp.SenderIDMismatchTokens, want) {
+		t.Fatalf("expect %v to be pruned and flagged, got %v removed and %v flagged", want, store.removed, resp.SenderIDMismatchTokens)
+	}
+
+	_, err = sender.Send(NewMessage(nil, "other-project"), creds)
+	if !errors.Is(err, ErrSenderIDMismatch) || errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expect ErrSenderIDMismatch, got %v", err)
+	}
+	var fcmErr *FCMError
+	if !errors.As(err, &fcmErr) || fcmErr.FCMStatus() != StatusSenderIDMismatch || fcmErr.FCMStatus().IsRetryable() {
+		t.Fatalf("expect the non-retryable sender ID mismatch status, got %v", err)
+	}
+
+	store = &memoryTokenStore{}
+	if _, err := sender.SendAndPrune(NewMessage(nil, "bad-message"), store, creds); err == nil || len(store.removed) != 0 {
+		t.Fatalf("expect an invalid message to fail without pruning, got %v with %v removed", err, store.removed)
+	}
+}
@@ -0,0 +1,44 @@
+package gcm
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRetryAfter caps the Retry-After delay a retry waits for, so that a
+// misbehaving server can not block a send for hours.
+const maxRetryAfter = time.Minute
+
+// quotaHeaders returns the rate limit and quota headers of a response,
+// Retry-After and the X-RateLimit-* headers, or nil if there are none.
+func quotaHeaders(header http.Header) map[string]string {
+	var headers map[string]string
+	for k, v := range header {
+		if len(v) == 0 || (k != "Retry-After" && !strings.HasPrefix(k, "X-Ratelimit-")) {
+			continue
+		}
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[k] = v[0]
+	}
+	return headers
+}
+
+// retryAfter returns the delay of the Retry-After header of headers, given
+// in seconds or as an HTTP date, or 0 if there is none.
+func retryAfter(headers map[string]string, now time.Time) time.Duration {
+	v, ok := headers["Retry-After"]
+	if !ok {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package gcm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQuotaHeaders(t *testing.T) {
+	var sends int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+		sends++
+		w.Header().Set("X-RateLimit-Remaining", "99")
+		w.Header().Set("X-Unrelated", "1")
+		if sends == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":{"code":429,"status":"RESOURCE_EXHAUSTED","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"QUOTA_EXCEEDED"}]}}`)
+			return
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	_, err = sender.Send(NewMessage(nil, "1"), creds)
+	var fcmErr *FCMError
+	if !errors.As(err, &fcmErr) || fcmErr.QuotaHeaders["Retry-After"] != "0" || fcmErr.QuotaHeaders["X-Ratelimit-Remaining"] != "99" {
+		t.Fatalf("expect the quota headers of the error, got %v", err)
+	}
+
+	resp, err := sender.Send(NewMessage(nil, "1"), creds)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if headers := resp.Results[0].QuotaHeaders; len(headers) != 1 || headers["X-Ratelimit-Remaining"] != "99" {
+		t.Fatalf("expect only the quota headers, got %v", headers)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	clock := newFakeClock()
+	sender, err := NewClient("http://localhost", "testAPIKey", WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.Backoff = ConstantBackoff(time.Second)
+
+	cases := []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"", time.Second},
+		{"5", 5 * time.Second},
+		{"3600", maxRetryAfter},
+		{clock.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 10 * time.Second},
+		{"soon", time.Second},
+	}
+	for i, tc := range cases {
+		fcmErr := &FCMError{StatusCode: http.StatusTooManyRequests}
+		if tc.retryAfter != "" {
+			fcmErr.QuotaHeaders = map[string]string{"Retry-After": tc.retryAfter}
+		}
+		if got := sender.retryDelay(1, fcmErr); got != tc.want {
+			t.Fatalf("#%d expect a delay of %s, got %s", i, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package gcm
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// WithRateLimit limits the client to r messages per second with bursts of at
+// most burst messages, to stay under the FCM project quota. Each send request
+// waits for a token before it is issued, and a batch request waits for one
+// token per message. Without this option the rate is unlimited.
+func WithRateLimit(r rate.Limit, burst int) ClientOption {
+	return func(c *Client) {
+		c.limiter = rate.NewLimiter(r, burst)
+	}
+}
+
+// waitRateLimit blocks until the rate limiter allows n messages or ctx is done.
+func (c *Client) waitRateLimit(ctx context.Context, n int) error {
+	if c.limiter == nil {
+		return nil
+	}
+
+	burst := c.limiter.Burst()
+	if burst <= 0 {
+		burst = 1
+	}
+	for n > 0 {
+		m := n
+		if m > burst {
+			m = burst
+		}
+		if err := c.limiter.WaitN(ctx, m); err != nil {
+			return err
+		}
+		n -= m
+	}
+
+	return nil
+}
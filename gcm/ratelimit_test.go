@@ -0,0 +1,29 @@
+package gcm
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	server := startTestServer(t, &testResponse{Response: &Response{}})
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey", WithRateLimit(rate.Every(20*time.Millisecond), 1))
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	start := time.Now()
+	if _, err := sender.Send(NewMessage(nil, "1", "2", "3", "4"), creds); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+
+	// The first request is allowed by the burst, the other three wait.
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatalf("expect the requests to be rate limited, took %s", elapsed)
+	}
+}
@@ -1,18 +1,271 @@
 package gcm
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
 // Response represents the FCM server's response to the application
 // server's sent message. See the documentation for FCM Architectural
 // Overview for more information:
 // https://firebase.google.com/docs/cloud-messaging/http-server-ref
+//
+// Validated is true when the message was a dry run: FCM validated the message
+// but did not deliver it, so the Results carry no message IDs.
+//
+// TokenLatency is the time fetching the access token took, which is
+// usually zero while the cached token is valid.
+//
+// FailureCount is the number of Results with an error. With
+// Client.PruneUnregistered the tokens FCM reports as unregistered or as
+// belonging to another project are not counted as failures but listed in
+// InvalidTokens, so that they can be removed from a token store.
+//
+// SenderIDMismatchTokens are the tokens FCM rejected with
+// SENDER_ID_MISMATCH, whether pruned or not. They usually point at
+// misconfigured credentials or token data rather than at uninstalled apps,
+// so they are worth logging or alerting on separately.
 type Response struct {
-	MulticastID  int64    `json:"multicast_id"`
-	CanonicalIDs int      `json:"canonical_ids"`
-	Results      []Result `json:"results"`
+	MulticastID   int64    `json:"multicast_id"`
+	CanonicalIDs  int      `json:"canonical_ids"`
+	FailureCount  int      `json:"failure"`
+	InvalidTokens []string `json:"invalid_tokens,omitempty"`
+	Results       []Result `json:"results"`
+	Validated     bool     `json:"validated,omitempty"`
+
+	SenderIDMismatchTokens []string `json:"sender_id_mismatch_tokens,omitempty"`
+
+	TokenLatency time.Duration `json:"token_latency,omitempty"`
+}
+
+// classify counts the failed Results and, with pruneUnregistered, collects
+// the unregistered and SENDER_ID_MISMATCH tokens into InvalidTokens instead.
+// With pruneInvalidArgument the INVALID_ARGUMENT results, which are only
+// kept for malformed tokens, are collected too.
+func (r *Response) classify(pruneUnregistered, pruneInvalidArgument bool) {
+	r.FailureCount = 0
+	r.InvalidTokens = nil
+	r.SenderIDMismatchTokens = nil
+	for _, result := range r.Results {
+		if result.Error == fcmErrorCodeSenderIDMismatch {
+			r.SenderIDMismatchTokens = append(r.SenderIDMismatchTokens, result.Token)
+		}
+
+		switch {
+		case result.Error == "":
+		case pruneUnregistered && isPrunableCode(result.Error),
+			pruneInvalidArgument && result.Error == fcmErrorCodeInvalidArgument:
+			r.InvalidTokens = append(r.InvalidTokens, result.Token)
+		default:
+			r.FailureCount++
+		}
+	}
+}
+
+// Err returns the outcome of the send of r as an error: nil when no token
+// failed, a *PartialFailureError matching ErrPartialFailure when some but not
+// all of the tokens failed, and an error matching ErrAllFailed when all of
+// them did. Pruned tokens in InvalidTokens are not failures.
+func (r *Response) Err() error {
+	return r.err(nil)
+}
+
+// err is like Err, with cause as the cause of the failures if not nil.
+func (r *Response) err(cause error) error {
+	switch {
+	case r.FailureCount == 0 && cause == nil:
+		return nil
+	case r.FailureCount < len(r.Results):
+		return &PartialFailureError{Response: r, Err: cause}
+	default:
+		return errors.Join(ErrAllFailed, cause)
+	}
 }
 
-// Result represents the status of a processed message.
+// MessageIDs returns the message name FCM assigned to each successfully
+// sent registration token, keyed by token.
+func (r *Response) MessageIDs() map[string]string {
+	ids := make(map[string]string, len(r.Results))
+	for _, result := range r.Results {
+		if result.Error == "" && result.MessageID != "" {
+			ids[result.Token] = result.MessageID
+		}
+	}
+	return ids
+}
+
+// Result represents the status of a processed message. Token is the
+// registration token the message was sent to and MessageID is the message
+// name FCM assigned, e.g. "projects/myproject/messages/0:1500415314455276%31bd1c9631bd1c96".
+// CorrelationID and Meta are the Message.CorrelationID and Message.Meta of
+// the message; Meta is not marshaled to JSON.
+//
+// Latency is the time the HTTP round-trips of the send took, excluding the
+// validation, the token fetch and the waits between retries, and Attempts
+// is the number of requests sent, more than one when the send was retried.
+// The results of a batch request share its latency.
+//
+// Error is the FCM error code of a failed send, e.g. "UNREGISTERED", or the
+// description of an error without one. ErrorStatus and ErrorMessage are the
+// canonical status and the description FCM responded with, if any.
+//
+// QuotaHeaders are the rate limit and quota headers of the response, e.g.
+// Retry-After or X-RateLimit-Remaining, keyed by their canonical name, so
+// that the caller can slow down before FCM starts to respond with 429.
+//
+// A Result is marshaled to JSON with the error as an object, e.g.
+// {"token":"...","message_id":"","registration_id":"","error":{"code":"UNREGISTERED","status":"NOT_FOUND","message":"Requested entity was not found."}},
+// so that the outcome of a send can be logged as a structured record.
 type Result struct {
-	MessageID      string `json:"message_id"`
-	RegistrationID string `json:"registration_id"`
-	Error          string `json:"error"`
+	Token          string
+	MessageID      string
+	RegistrationID string
+	Error          string
+	ErrorStatus    string
+	ErrorMessage   string
+	CorrelationID  string
+	Latency        time.Duration
+	Attempts       int
+	QuotaHeaders   map[string]string
+	Meta           interface{}
+}
+
+// resultJSON is the JSON representation of a Result.
+type resultJSON struct {
+	Token          string            `json:"token,omitempty"`
+	MessageID      string            `json:"message_id"`
+	RegistrationID string            `json:"registration_id"`
+	Error          *resultErrorJSON  `json:"error,omitempty"`
+	CorrelationID  string            `json:"correlation_id,omitempty"`
+	Latency        time.Duration     `json:"latency,omitempty"`
+	Attempts       int               `json:"attempts,omitempty"`
+	QuotaHeaders   map[string]string `json:"quota_headers,omitempty"`
+}
+
+type resultErrorJSON struct {
+	Code    string `json:"code"`
+	Status  string `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (r Result) MarshalJSON() ([]byte, error) {
+	v := resultJSON{
+		Token:          r.Token,
+		MessageID:      r.MessageID,
+		RegistrationID: r.RegistrationID,
+		CorrelationID:  r.CorrelationID,
+		Latency:        r.Latency,
+		Attempts:       r.Attempts,
+		QuotaHeaders:   r.QuotaHeaders,
+	}
+	if r.Error != "" || r.ErrorStatus != "" || r.ErrorMessage != "" {
+		v.Error = &resultErrorJSON{Code: r.Error, Status: r.ErrorStatus, Message: r.ErrorMessage}
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a Result marshaled by MarshalJSON. An error given as
+// a plain string, as older versions marshaled it, is read as the code.
+func (r *Result) UnmarshalJSON(b []byte) error {
+	var v struct {
+		resultJSON
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	*r = Result{
+		Token:          v.Token,
+		MessageID:      v.MessageID,
+		RegistrationID: v.RegistrationID,
+		CorrelationID:  v.CorrelationID,
+		Latency:        v.Latency,
+		Attempts:       v.Attempts,
+		QuotaHeaders:   v.QuotaHeaders,
+	}
+	if len(v.Error) == 0 || string(v.Error) == "null" {
+		return nil
+	}
+	if v.Error[0] == '"' {
+		return json.Unmarshal(v.Error, &r.Error)
+	}
+
+	var e resultErrorJSON
+	if err := json.Unmarshal(v.Error, &e); err != nil {
+		return err
+	}
+	r.Error, r.ErrorStatus, r.ErrorMessage = e.Code, e.Status, e.Message
+	return nil
+}
+
+// newErrorResult returns the Result of a send to token failing with err.
+func newErrorResult(token string, err error) Result {
+	var fcmErr *FCMError
+	if errors.As(err, &fcmErr) && fcmErr.code() != "" {
+		return Result{Token: token, Error: fcmErr.code(), ErrorStatus: fcmErr.Status, ErrorMessage: fcmErr.Message, QuotaHeaders: fcmErr.QuotaHeaders}
+	}
+	return Result{Token: token, Error: err.Error()}
+}
+
+// SendResult is the outcome of a send to a single registration token, see
+// Client.SendSingle. When the send failed, Err returns the SendResult itself
+// as an error, so that the FCM status and the token can be read with
+// errors.As and the cause, e.g. an *FCMError, with errors.Unwrap.
+type SendResult struct {
+	Token string
+	// MessageID is the message name FCM assigned when the send succeeded.
+	MessageID string
+	// StatusCode, Status and ErrorCode are those of the FCMError of a send
+	// rejected by FCM, see FCMError.
+	StatusCode int
+	Status     string
+	ErrorCode  string
+
+	err error
+}
+
+// newSendResult returns the SendResult of a single token send returning
+// resp and err.
+func newSendResult(token string, resp *Response, err error) *SendResult {
+	r := &SendResult{Token: token, err: err}
+	if err == nil && len(resp.Results) == 1 {
+		result := resp.Results[0]
+		r.MessageID = result.MessageID
+		if result.Error != "" {
+			r.ErrorCode = result.Error
+			r.err = errors.New(result.Error)
+		}
+	}
+
+	var fcmErr *FCMError
+	if errors.As(err, &fcmErr) {
+		r.StatusCode = fcmErr.StatusCode
+		r.Status = fcmErr.Status
+		r.ErrorCode = fcmErr.ErrorCode
+	}
+
+	return r
+}
+
+// Err returns nil when the send succeeded, and the SendResult otherwise.
+func (r *SendResult) Err() error {
+	if r.err == nil {
+		return nil
+	}
+	return r
+}
+
+func (r *SendResult) Error() string {
+	if r.err == nil {
+		return fmt.Sprintf("sent to %s as %s", r.Token, r.MessageID)
+	}
+	return fmt.Sprintf("failed to send to %s: %v", r.Token, r.err)
+}
+
+// Unwrap returns the cause of a failed send.
+func (r *SendResult) Unwrap() error {
+	return r.err
 }
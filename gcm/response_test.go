@@ -0,0 +1,67 @@
+package gcm
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResultJSON(t *testing.T) {
+	response := &Response{
+		FailureCount: 1,
+		Results: []Result{
+			{Token: "1", MessageID: "projects/test/messages/1", CorrelationID: "campaign-1"},
+			{Token: "2", Error: "UNREGISTERED", ErrorStatus: "NOT_FOUND", ErrorMessage: "Requested entity was not found.", CorrelationID: "campaign-1"},
+		},
+	}
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		t.Fatalf("failed to marshal the response: %v", err)
+	}
+	if want := `"error":{"code":"UNREGISTERED","status":"NOT_FOUND","message":"Requested entity was not found."}`; !strings.Contains(string(b), want) {
+		t.Fatalf("expect the error to be an object %s, got %s", want, b)
+	}
+	if strings.Count(string(b), `"error"`) != 1 {
+		t.Fatalf("expect no error in a successful result, got %s", b)
+	}
+
+	var decoded Response
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal the response: %v", err)
+	}
+	if !reflect.DeepEqual(&decoded, response) {
+		t.Fatalf("expect the response to round-trip, got %+v", decoded)
+	}
+
+	var legacy Result
+	if err := json.Unmarshal([]byte(`{"token":"3","message_id":"","registration_id":"","error":"NotRegistered"}`), &legacy); err != nil {
+		t.Fatalf("failed to unmarshal a legacy result: %v", err)
+	}
+	if legacy.Token != "3" || legacy.Error != "NotRegistered" {
+		t.Fatalf("expect a string error to be read as the code, got %+v", legacy)
+	}
+}
+
+func TestResponseErr(t *testing.T) {
+	resp := &Response{Results: []Result{{Token: "1"}, {Token: "2", Error: "UNREGISTERED"}}}
+	resp.classify(false, false)
+	err := resp.Err()
+	var partial *PartialFailureError
+	if !errors.Is(err, ErrPartialFailure) || !errors.As(err, &partial) || partial.Response != resp {
+		t.Fatalf("expect a partial failure, got %v", err)
+	}
+
+	resp.classify(true, false)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("expect the pruned token not to fail, got %v", err)
+	}
+
+	resp = &Response{Results: []Result{{Token: "1", Error: "INTERNAL"}}}
+	resp.classify(false, false)
+	if err := resp.Err(); !errors.Is(err, ErrAllFailed) || errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("expect ErrAllFailed, got %v", err)
+	}
+}
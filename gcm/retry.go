@@ -0,0 +1,157 @@
+package gcm
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Backoff decides how long to wait before retrying a failed request.
+// Implementations must be safe for concurrent use.
+type Backoff interface {
+	// Next returns the delay before the given retry, starting at 1.
+	Next(attempt int) time.Duration
+}
+
+// DefaultBackoff is the Backoff used when Client.Backoff is nil. It waits a
+// random duration between 0 and 1s, 2s, 4s, ... capped at 32s.
+var DefaultBackoff Backoff = ExponentialBackoff{Base: time.Second, Max: 32 * time.Second}
+
+const (
+	// maxTokenRetries is the number of times fetching an access token is
+	// retried after a transient failure, independently of Client.MaxRetries.
+	maxTokenRetries = 2
+)
+
+// tokenBackoff decides how long to wait before retrying to fetch an access
+// token. The token endpoint usually recovers quickly, so the delays are short.
+var tokenBackoff Backoff = ExponentialBackoff{Base: 100 * time.Millisecond, Max: time.Second}
+
+// ExponentialBackoff doubles the delay with every retry starting at Base and
+// capped at Max, and applies full jitter: the returned delay is random
+// between 0 and the exponential delay.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b ExponentialBackoff) Next(attempt int) time.Duration {
+	delay := b.Base
+	for i := 1; i < attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if b.Max > 0 && delay > b.Max {
+		delay = b.Max
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// ConstantBackoff waits the same duration before every retry without jitter.
+// ConstantBackoff(0) retries immediately, which keeps retry tests fast and
+// deterministic.
+type ConstantBackoff time.Duration
+
+func (b ConstantBackoff) Next(attempt int) time.Duration {
+	return time.Duration(b)
+}
+
+func (c *Client) backoff() Backoff {
+	if c.Backoff != nil {
+		return c.Backoff
+	}
+	return DefaultBackoff
+}
+
+// retryDelay returns how long to wait before the given retry of a request
+// failing with err: the delay of the backoff, or the Retry-After delay the
+// FCM server asked for, up to a minute, if it is longer.
+func (c *Client) retryDelay(attempt int, err error) time.Duration {
+	delay := c.backoff().Next(attempt)
+	var fcmErr *FCMError
+	if errors.As(err, &fcmErr) {
+		if after := retryAfter(fcmErr.QuotaHeaders, c.now()); after > delay {
+			delay = after
+			if delay > maxRetryAfter {
+				delay = maxRetryAfter
+			}
+		}
+	}
+	return delay
+}
+
+// IsRetryable reports whether a send failing with err may succeed when it is
+// sent again later, as the retries of the client decide:
+//
+//   - an *FCMError with a retryable FCMStatus, e.g. UNAVAILABLE or INTERNAL,
+//     or else with a 429 or 5xx status code, is retryable, while the others,
+//     e.g. UNREGISTERED, INVALID_ARGUMENT or a 400, 401 or 404 response, are
+//     permanent;
+//   - a failure to fetch the access token is retryable unless the token
+//     endpoint rejected the credentials or the request;
+//   - ErrCircuitOpen and network errors, including timeouts, are retryable;
+//   - context.Canceled and a bare context.DeadlineExceeded are not, and
+//     neither is any other error.
+//
+// A request timing out is a network error even when the deadline of its
+// context is exceeded, so a retry loop should also stop once its context is
+// done.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var fcmErr *FCMError
+	if errors.As(err, &fcmErr) {
+		if status := fcmErr.FCMStatus(); status != StatusUnknown {
+			return status.IsRetryable()
+		}
+		return fcmErr.StatusCode == http.StatusTooManyRequests || fcmErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return shouldRetryToken(err)
+	}
+
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// context.DeadlineExceeded is a net.Error itself, unlike the timeout of
+	// a request, which is an *url.Error wrapping it.
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr != context.DeadlineExceeded
+}
+
+// shouldRetryToken reports whether fetching an access token failing with err
+// may succeed when it is fetched again. Network errors and 429 or 5xx
+// responses of the token endpoint are transient; any other error, e.g. the
+// token endpoint rejecting the credentials or a malformed private key, is
+// permanent.
+func shouldRetryToken(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if retrieveErr.Response == nil {
+			return false
+		}
+		statusCode := retrieveErr.Response.StatusCode
+		return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
@@ -0,0 +1,247 @@
+package gcm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Base: time.Second, Max: 4 * time.Second}
+	cases := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{10, 4 * time.Second},
+	}
+
+	for _, tc := range cases {
+		for i := 0; i < 100; i++ {
+			if d := b.Next(tc.attempt); d < 0 || d > tc.max {
+				t.Fatalf("attempt %d: expect delay between 0 and %s, got %s", tc.attempt, tc.max, d)
+			}
+		}
+	}
+
+	if d := ConstantBackoff(0).Next(3); d != 0 {
+		t.Fatalf("expect no delay, got %s", d)
+	}
+}
+
+func TestSendRetry(t *testing.T) {
+	cases := []struct {
+		failures   int
+		status     int
+		maxRetries int
+		success    bool
+		requests   int
+	}{
+		{0, http.StatusServiceUnavailable, 0, true, 1},
+		{1, http.StatusServiceUnavailable, 0, false, 1},
+		{2, http.StatusServiceUnavailable, 2, true, 3},
+		{3, http.StatusInternalServerError, 2, false, 3},
+		{1, http.StatusTooManyRequests, 1, true, 2},
+		{1, http.StatusBadRequest, 3, false, 1},
+	}
+
+	for i, tc := range cases {
+		var requests int
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/token" {
+				serveTestToken(w)
+				return
+			}
+			requests++
+			if requests <= tc.failures {
+				w.WriteHeader(tc.status)
+				return
+			}
+			fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+		}
+		server := httptest.NewServer(http.HandlerFunc(handler))
+
+		sender, err := NewClient(server.URL, "testAPIKey")
+		if err != nil {
+			t.Fatalf("Failed to setup sender client: %s", err)
+		}
+		sender.MaxRetries = tc.maxRetries
+		sender.Backoff = ConstantBackoff(0)
+
+		_, err = sender.Send(NewMessage(nil, "1"), testCredentials(t, server.URL+"/token"))
+		server.Close()
+
+		if (err == nil) != tc.success {
+			t.Fatalf("#%d expect success to be %v, got %v", i, tc.success, err)
+		}
+		if requests != tc.requests {
+			t.Fatalf("#%d expect %d requests, got %d", i, tc.requests, requests)
+		}
+	}
+}
+
+func TestAccessTokenRetry(t *testing.T) {
+	cases := []struct {
+		status  int
+		body    string
+		success bool
+		fetches int
+	}{
+		{http.StatusServiceUnavailable, `{}`, true, 2},
+		{http.StatusBadRequest, `{"error":"invalid_grant"}`, false, 1},
+	}
+
+	for i, tc := range cases {
+		var fetches int
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			fetches++
+			if fetches == 1 {
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+				return
+			}
+			serveTestToken(w)
+		}
+		server := httptest.NewServer(http.HandlerFunc(handler))
+
+		sender, err := NewClient(server.URL, "testAPIKey")
+		if err != nil {
+			t.Fatalf("Failed to setup sender client: %s", err)
+		}
+
+		_, err = sender.accessToken(context.Background(), testCredentials(t, server.URL))
+		server.Close()
+
+		if (err == nil) != tc.success {
+			t.Fatalf("#%d expect success to be %v, got %v", i, tc.success, err)
+		}
+		if fetches != tc.fetches {
+			t.Fatalf("#%d expect %d token fetches, got %d", i, tc.fetches, fetches)
+		}
+	}
+}
+
+// wrappedTokenSource counts the tokens fetched from ts.
+type wrappedTokenSource struct {
+	ts      oauth2.TokenSource
+	fetches int
+}
+
+func (s *wrappedTokenSource) Token() (*oauth2.Token, error) {
+	s.fetches++
+	return s.ts.Token()
+}
+
+func TestAccessTokenBadPrivateKey(t *testing.T) {
+	creds, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"private_key":  "not a private key",
+		"client_email": "test@test-project.iam.gserviceaccount.com",
+		"token_uri":    "http://localhost/token",
+	})
+	config, err := google.JWTConfigFromJSON(creds, MessagingScope)
+	if err != nil {
+		t.Fatalf("failed to parse the credentials: %s", err)
+	}
+	ts := &wrappedTokenSource{ts: config.TokenSource(context.Background())}
+
+	sender, err := NewClientWithTokenSource("http://localhost", ts)
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	if _, err := sender.accessToken(context.Background(), nil); err == nil {
+		t.Fatalf("expect to be failed (bad private key)")
+	}
+	if ts.fetches != 1 {
+		t.Fatalf("expect the bad private key not to be retried, got %d token fetches", ts.fetches)
+	}
+}
+
+func TestSendLatencyAndAttempts(t *testing.T) {
+	clock := newFakeClock()
+	var sends int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			clock.Add(5 * time.Millisecond)
+			serveTestToken(w)
+			return
+		}
+		clock.Add(10 * time.Millisecond)
+		sends++
+		if sends == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"name":"projects/test/messages/1"}`)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey", WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.MaxRetries = 1
+	sender.Backoff = ConstantBackoff(time.Millisecond)
+
+	resp, err := sender.Send(NewMessage(nil, "1"), testCredentials(t, server.URL+"/token"))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if resp.TokenLatency != 5*time.Millisecond {
+		t.Fatalf("expect the token latency to be 5ms, got %s", resp.TokenLatency)
+	}
+	if result := resp.Results[0]; result.Attempts != 2 || result.Latency != 20*time.Millisecond {
+		t.Fatalf("expect 2 attempts taking 20ms, got %d attempts taking %s", result.Attempts, result.Latency)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	fcmError := func(statusCode int, body string) error {
+		return fmt.Errorf("failed to send: %w", newFCMError(statusCode, http.StatusText(statusCode), []byte(body)))
+	}
+	tokenError := func(statusCode int) error {
+		return &oauth2.RetrieveError{Response: &http.Response{StatusCode: statusCode}}
+	}
+
+	testCases := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{fcmError(http.StatusTooManyRequests, `{"error":{"code":429,"status":"RESOURCE_EXHAUSTED"}}`), true},
+		{fcmError(http.StatusInternalServerError, `{"error":{"code":500,"status":"INTERNAL"}}`), true},
+		{fcmError(http.StatusServiceUnavailable, `{"error":{"code":503,"status":"UNAVAILABLE"}}`), true},
+		{fcmError(http.StatusBadGateway, ""), true},
+		{fcmError(http.StatusBadRequest, `{"error":{"code":400,"status":"INVALID_ARGUMENT"}}`), false},
+		{fcmError(http.StatusUnauthorized, ""), false},
+		{fcmError(http.StatusNotFound, `{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`), false},
+		{tokenError(http.StatusServiceUnavailable), true},
+		{tokenError(http.StatusBadRequest), false},
+		{tokenError(http.StatusTooManyRequests), true},
+		{ErrCircuitOpen, true},
+		{&url.Error{Op: "Post", URL: "https://fcm.googleapis.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{&url.Error{Op: "Post", URL: "https://fcm.googleapis.com", Err: context.Canceled}, false},
+		{context.DeadlineExceeded, false},
+		{&ValidationError{Field: "TimeToLive", Reason: "must not be negative"}, false},
+		{ErrClientClosed, false},
+	}
+	for i, tc := range testCases {
+		if retryable := IsRetryable(tc.err); retryable != tc.retryable {
+			t.Fatalf("#%d expect IsRetryable(%v) to be %v, got %v", i, tc.err, tc.retryable, retryable)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package gcm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ScheduledSend is a send that fires at a later time, created by SendAt.
+type ScheduledSend struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	resp   *Response
+	err    error
+}
+
+// Cancel cancels the send if it has not fired yet, or aborts it if it is in
+// flight. Result then returns context.Canceled. Cancel may be called many
+// times.
+func (s *ScheduledSend) Cancel() {
+	s.cancel()
+}
+
+// Done returns a channel that is closed when the send finished or was
+// canceled.
+func (s *ScheduledSend) Done() <-chan struct{} {
+	return s.done
+}
+
+// Result waits for the send to finish and returns its outcome.
+func (s *ScheduledSend) Result() (*Response, error) {
+	<-s.done
+	return s.resp, s.err
+}
+
+// SendAt sends msg at the given time. FCM can not schedule a delivery, so the
+// client keeps a timer and sends the message when it fires. The send is
+// canceled when ctx is done or the returned handle is canceled. The message is
+// validated immediately; msg must not be modified until the send is done. A
+// time in the past is an error.
+func (c *Client) SendAt(ctx context.Context, at time.Time, msg *Message, acsJsonData []byte, opts ...SendOption) (*ScheduledSend, error) {
+	delay := at.Sub(c.now())
+	if delay < 0 {
+		return nil, fmt.Errorf("the send time %s is in the past", at.Format(time.RFC3339))
+	}
+
+	msg = c.normalizeTokens(msg)
+	if err := c.validate(msg); err != nil {
+		return nil, err
+	}
+
+	o := newSendOptions(opts)
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &ScheduledSend{cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(s.done)
+		defer cancel()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			s.err = ctx.Err()
+		case <-timer.C:
+			s.resp, s.err = c.send(ctx, msg, acsJsonData, o)
+		}
+	}()
+
+	return s, nil
+}
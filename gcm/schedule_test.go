@@ -0,0 +1,51 @@
+package gcm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSendAt(t *testing.T) {
+	server := startTestServer(t, &testResponse{Response: &Response{}})
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	creds := testCredentials(t, server.URL+"/token")
+
+	if _, err := sender.SendAt(context.Background(), time.Now().Add(-time.Minute), NewMessage(nil, "1"), creds); err == nil {
+		t.Fatalf("expect to be failed (time in the past)")
+	}
+
+	scheduled, err := sender.SendAt(context.Background(), time.Now().Add(10*time.Millisecond), NewMessage(nil, "1"), creds)
+	if err != nil {
+		t.Fatalf("expect SendAt() to be success: %v", err)
+	}
+	if resp, err := scheduled.Result(); err != nil || len(resp.Results) != 1 {
+		t.Fatalf("expect the scheduled send to be success, got %v", err)
+	}
+
+	scheduled, err = sender.SendAt(context.Background(), time.Now().Add(time.Hour), NewMessage(nil, "1"), creds)
+	if err != nil {
+		t.Fatalf("expect SendAt() to be success: %v", err)
+	}
+	scheduled.Cancel()
+	if _, err := scheduled.Result(); err != context.Canceled {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduled, err = sender.SendAt(ctx, time.Now().Add(time.Hour), NewMessage(nil, "1"), creds)
+	if err != nil {
+		t.Fatalf("expect SendAt() to be success: %v", err)
+	}
+	cancel()
+	select {
+	case <-scheduled.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expect the scheduled send to be canceled with its context")
+	}
+}
@@ -0,0 +1,135 @@
+package gcm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Sender sends messages to FCM. It is implemented by Client and FakeSender;
+// code sending notifications should depend on Sender rather than *Client so
+// that FakeSender can be injected in its tests.
+type Sender interface {
+	Send(msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error)
+	SendContext(ctx context.Context, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error)
+	SendOne(token string, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error)
+	SendToDeviceGroup(notificationKey string, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error)
+	SendBatch(msg *Message, acsJsonData []byte) (*Response, error)
+}
+
+var (
+	_ Sender = (*Client)(nil)
+	_ Sender = (*FakeSender)(nil)
+)
+
+// FakeSender is an in-memory Sender for tests. It records the messages sent
+// through it and, unless configured otherwise, reports each registration ID
+// as delivered with a fake message name. Messages are normalized and
+// validated like a Client with the default settings does, so an invalid
+// message fails. FakeSender is safe for concurrent use.
+type FakeSender struct {
+	// Response, if set, is returned by every send instead of the default.
+	Response *Response
+	// Err, if set, is returned by every send.
+	Err error
+
+	mu       sync.Mutex
+	messages []*Message
+	sent     int
+}
+
+// Messages returns copies of the messages sent so far, in order. Messages
+// sent with SendOne or SendToDeviceGroup hold the target as their only
+// registration ID.
+func (f *FakeSender) Messages() []*Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	messages := make([]*Message, len(f.messages))
+	for i, msg := range f.messages {
+		messages[i] = msg.Clone()
+	}
+	return messages
+}
+
+// Reset forgets the messages sent so far.
+func (f *FakeSender) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.messages = nil
+}
+
+func (f *FakeSender) Send(msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	return f.send(msg, newSendOptions(opts))
+}
+
+func (f *FakeSender) SendContext(ctx context.Context, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.send(msg, newSendOptions(opts))
+}
+
+func (f *FakeSender) SendOne(token string, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	oneMsg, err := oneMessage(token, msg)
+	if err != nil {
+		return nil, err
+	}
+	return f.send(oneMsg, newSendOptions(opts))
+}
+
+func (f *FakeSender) SendToDeviceGroup(notificationKey string, msg *Message, acsJsonData []byte, opts ...SendOption) (*Response, error) {
+	notificationKey = strings.TrimSpace(notificationKey)
+	if len(notificationKey) == 0 {
+		return nil, fmt.Errorf("the notification key must not be empty")
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("the message must not be nil")
+	}
+
+	groupMsg := *msg
+	groupMsg.RegistrationIDs = []string{notificationKey}
+	return f.send(&groupMsg, newSendOptions(opts))
+}
+
+func (f *FakeSender) SendBatch(msg *Message, acsJsonData []byte) (*Response, error) {
+	return f.send(msg, &sendOptions{})
+}
+
+func (f *FakeSender) send(msg *Message, o *sendOptions) (*Response, error) {
+	defaults := &Client{}
+	msg = defaults.normalizeTokens(msg)
+	if err := defaults.validate(msg); err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.messages = append(f.messages, msg.Clone())
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if f.Response != nil {
+		return f.Response, nil
+	}
+
+	validateOnly := o.validateOnly(msg)
+	response := &Response{Validated: validateOnly}
+	for _, token := range msg.targets() {
+		result := Result{Token: token, CorrelationID: msg.CorrelationID, Meta: msg.Meta}
+		if !validateOnly {
+			f.sent++
+			result.MessageID = fmt.Sprintf("projects/fake/messages/%d", f.sent)
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	return response, nil
+}
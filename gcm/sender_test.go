@@ -0,0 +1,63 @@
+package gcm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFakeSender(t *testing.T) {
+	var sender Sender = &FakeSender{}
+
+	resp, err := sender.Send(NewMessage(nil, "1", "2"), nil)
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if len(resp.Results) != 2 || resp.Results[1].MessageID != "projects/fake/messages/2" {
+		t.Fatalf("unexpected results: %+v", resp.Results)
+	}
+
+	if _, err := sender.SendOne(" 3 ", NewMessage(map[string]interface{}{"key": "value"}), nil); err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if _, err := sender.SendOne("  ", NewMessage(nil), nil); err == nil {
+		t.Fatalf("expect to be failed (empty token)")
+	}
+	if _, err := sender.SendOne("5", NewMessage(nil, "6"), nil); err == nil {
+		t.Fatalf("expect to be failed (other registration IDs)")
+	}
+	var validationErr *ValidationError
+	if _, err := sender.SendOne("5", NewMessage(map[string]interface{}{"key": make(chan int)}), nil); !errors.As(err, &validationErr) {
+		t.Fatalf("expect a validation error of the data, got %v", err)
+	}
+
+	resp, err = sender.Send(NewMessage(nil, "4"), nil, WithDryRun(true))
+	if err != nil {
+		t.Fatalf("expect to be success: %v", err)
+	}
+	if !resp.Validated || resp.Results[0].MessageID != "" {
+		t.Fatalf("expect a validated response without message IDs, got %+v", resp)
+	}
+
+	if _, err := sender.Send(NewMessage(nil), nil); err == nil {
+		t.Fatalf("expect to be failed (no registration IDs)")
+	}
+
+	fake := sender.(*FakeSender)
+	messages := fake.Messages()
+	if len(messages) != 3 || messages[1].RegistrationIDs[0] != "3" {
+		t.Fatalf("expect the sent messages to be recorded, got %+v", messages)
+	}
+	messages[1].Data["key"] = "changed"
+	if fake.Messages()[1].Data["key"] != "value" {
+		t.Fatalf("expect Messages to return copies")
+	}
+
+	fake.Reset()
+	fake.Err = errors.New("unavailable")
+	if _, err := sender.Send(NewMessage(nil, "1"), nil); err != fake.Err {
+		t.Fatalf("expect the configured error, got %v", err)
+	}
+	if len(fake.Messages()) != 1 {
+		t.Fatalf("expect a failed send to be recorded too")
+	}
+}
@@ -0,0 +1,87 @@
+package gcm
+
+// FCMErrorStatus is a well-known condition FCM reports an error with, parsed
+// from the FCM error code or the canonical status of the error, see
+// ParseFCMErrorStatus.
+// See more on https://firebase.google.com/docs/reference/fcm/rest/v1/ErrorCode
+type FCMErrorStatus int
+
+const (
+	// StatusUnknown is any status not listed below.
+	StatusUnknown FCMErrorStatus = iota
+	// StatusUnregistered means the registration token is no longer valid,
+	// e.g. the app was uninstalled.
+	StatusUnregistered
+	// StatusInvalidArgument means the message or its target is malformed.
+	StatusInvalidArgument
+	// StatusQuotaExceeded means the sending limit was exceeded.
+	StatusQuotaExceeded
+	// StatusUnavailable means the FCM server is overloaded.
+	StatusUnavailable
+	// StatusInternal means an unknown internal error of the FCM server.
+	StatusInternal
+	// StatusSenderIDMismatch means the registration token belongs to
+	// another Firebase project.
+	StatusSenderIDMismatch
+	// StatusThirdPartyAuthError means the APNs certificate or the web push
+	// auth key is invalid or missing.
+	StatusThirdPartyAuthError
+)
+
+// fcmErrorStatuses maps the FCM error codes and the canonical statuses FCM
+// responds with to their FCMErrorStatus.
+var fcmErrorStatuses = map[string]FCMErrorStatus{
+	fcmErrorCodeUnregistered:     StatusUnregistered,
+	"NOT_FOUND":                  StatusUnregistered,
+	fcmErrorCodeInvalidArgument:  StatusInvalidArgument,
+	"QUOTA_EXCEEDED":             StatusQuotaExceeded,
+	"RESOURCE_EXHAUSTED":         StatusQuotaExceeded,
+	"UNAVAILABLE":                StatusUnavailable,
+	"INTERNAL":                   StatusInternal,
+	fcmErrorCodeSenderIDMismatch: StatusSenderIDMismatch,
+	"THIRD_PARTY_AUTH_ERROR":     StatusThirdPartyAuthError,
+}
+
+// ParseFCMErrorStatus returns the FCMErrorStatus of an FCM error code, e.g.
+// "UNREGISTERED", or of a canonical status, e.g. "NOT_FOUND". An unknown
+// string is StatusUnknown.
+func ParseFCMErrorStatus(s string) FCMErrorStatus {
+	return fcmErrorStatuses[s]
+}
+
+// String returns the FCM error code of s, e.g. "UNREGISTERED".
+func (s FCMErrorStatus) String() string {
+	switch s {
+	case StatusUnregistered:
+		return fcmErrorCodeUnregistered
+	case StatusInvalidArgument:
+		return fcmErrorCodeInvalidArgument
+	case StatusQuotaExceeded:
+		return "QUOTA_EXCEEDED"
+	case StatusUnavailable:
+		return "UNAVAILABLE"
+	case StatusInternal:
+		return "INTERNAL"
+	case StatusSenderIDMismatch:
+		return fcmErrorCodeSenderIDMismatch
+	case StatusThirdPartyAuthError:
+		return "THIRD_PARTY_AUTH_ERROR"
+	}
+	return "UNKNOWN"
+}
+
+// IsRetryable reports whether sending again later may succeed, i.e. the
+// quota was exceeded or the FCM server failed.
+func (s FCMErrorStatus) IsRetryable() bool {
+	switch s {
+	case StatusQuotaExceeded, StatusUnavailable, StatusInternal:
+		return true
+	}
+	return false
+}
+
+// FCMStatus returns the FCMErrorStatus of e, from its FCM error code if any
+// or else from its canonical status.
+func (e *FCMError) FCMStatus() FCMErrorStatus {
+	return ParseFCMErrorStatus(e.code())
+}
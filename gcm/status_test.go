@@ -0,0 +1,39 @@
+package gcm
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseFCMErrorStatus(t *testing.T) {
+	cases := []struct {
+		s         string
+		status    FCMErrorStatus
+		retryable bool
+	}{
+		{"UNREGISTERED", StatusUnregistered, false},
+		{"NOT_FOUND", StatusUnregistered, false},
+		{"INVALID_ARGUMENT", StatusInvalidArgument, false},
+		{"QUOTA_EXCEEDED", StatusQuotaExceeded, true},
+		{"UNAVAILABLE", StatusUnavailable, true},
+		{"INTERNAL", StatusInternal, true},
+		{"SENDER_ID_MISMATCH", StatusSenderIDMismatch, false},
+		{"THIRD_PARTY_AUTH_ERROR", StatusThirdPartyAuthError, false},
+		{"SOMETHING_NEW", StatusUnknown, false},
+	}
+
+	for i, tc := range cases {
+		status := ParseFCMErrorStatus(tc.s)
+		if status != tc.status || status.IsRetryable() != tc.retryable {
+			t.Fatalf("#%d expect %s (retryable %v), got %s (retryable %v)", i, tc.status, tc.retryable, status, status.IsRetryable())
+		}
+		if status != StatusUnknown && ParseFCMErrorStatus(status.String()) != status {
+			t.Fatalf("#%d expect String() to round-trip, got %s", i, status)
+		}
+	}
+
+	fcmErr := newFCMError(http.StatusNotFound, "404 Not Found", []byte(`{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`))
+	if status := fcmErr.FCMStatus(); status != StatusUnregistered {
+		t.Fatalf("expect the FCMError to be %s, got %s", StatusUnregistered, status)
+	}
+}
@@ -0,0 +1,92 @@
+package gcm
+
+import (
+	"context"
+	"sync"
+)
+
+// SendStream sends the messages received from in with a pool of
+// StreamWorkers workers and emits one Result per registration ID on the
+// returned channel. A token that fails to be sent yields a Result with its
+// error, and the other tokens of its message are still sent. A message that
+// is invalid or fails as a whole, e.g. as the access token can not be
+// fetched, yields a Result with the error for each of its registration IDs. The access token is
+// fetched once and cached as with Send.
+//
+// Sending stops when in is closed or ctx is done; the returned channel is
+// closed after the messages in flight are finished. The caller must keep
+// receiving from it until it is closed, as the workers block while their
+// results are not received.
+func (c *Client) SendStream(ctx context.Context, in <-chan *Message, acsJsonData []byte, opts ...SendOption) <-chan Result {
+	workers := c.StreamWorkers
+	if workers <= 0 {
+		workers = defaultStreamWorkers
+	}
+
+	o := newSendOptions(opts)
+	out := make(chan Result, workers)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg, ok := <-in:
+					if !ok {
+						return
+					}
+					for _, result := range c.streamResults(ctx, msg, acsJsonData, o) {
+						select {
+						case out <- result:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// streamResults sends msg like SendEach and returns its results, or the
+// error as the result of each registration ID if msg is invalid or its send
+// failed as a whole.
+func (c *Client) streamResults(ctx context.Context, msg *Message, acsJsonData []byte, o *sendOptions) []Result {
+	msg = c.normalizeTokens(msg)
+	err := c.validate(msg)
+	if err == nil {
+		var resp *Response
+		resp, err = c.sendEach(ctx, msg, acsJsonData, o)
+		if resp != nil {
+			return resp.Results
+		}
+	}
+
+	if msg == nil {
+		return []Result{{Error: err.Error()}}
+	}
+	if len(msg.RegistrationIDs) == 0 {
+		return []Result{{Error: err.Error(), CorrelationID: msg.CorrelationID, Meta: msg.Meta}}
+	}
+
+	results := make([]Result, 0, len(msg.RegistrationIDs))
+	for _, token := range msg.RegistrationIDs {
+		result := newErrorResult(token, err)
+		result.CorrelationID = msg.CorrelationID
+		result.Meta = msg.Meta
+		results = append(results, result)
+	}
+	return results
+}
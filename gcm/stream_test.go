@@ -0,0 +1,84 @@
+package gcm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestSendStream(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			serveTestToken(w)
+			return
+		}
+
+		var received WrappedMessage
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid request body: %s", err)
+			return
+		}
+		if received.Message.Token == "broken" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"name":"projects/test/messages/%s"}`, received.Message.Token)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	sender, err := NewClient(server.URL, "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+	sender.StreamWorkers = 3
+
+	in := make(chan *Message)
+	go func() {
+		defer close(in)
+		for i := 0; i < 20; i++ {
+			in <- NewMessage(nil, strconv.Itoa(i))
+		}
+		in <- NewMessage(nil)
+		in <- NewMessage(nil, "20", "broken", "21")
+	}()
+
+	sent := map[string]bool{}
+	var failed int
+	for result := range sender.SendStream(context.Background(), in, testCredentials(t, server.URL+"/token")) {
+		if result.Error != "" {
+			failed++
+			continue
+		}
+		if result.MessageID != "projects/test/messages/"+result.Token {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+		sent[result.Token] = true
+	}
+
+	if len(sent) != 22 {
+		t.Fatalf("expect 22 tokens to be sent, got %d", len(sent))
+	}
+	if failed != 2 {
+		t.Fatalf("expect the invalid message and the broken token to yield 2 failed results, got %d", failed)
+	}
+}
+
+func TestSendStreamCancel(t *testing.T) {
+	sender, err := NewClient("http://localhost", "testAPIKey")
+	if err != nil {
+		t.Fatalf("Failed to setup sender client: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := sender.SendStream(ctx, make(chan *Message), nil)
+	cancel()
+
+	if _, ok := <-out; ok {
+		t.Fatalf("expect the results to be closed after the context is canceled")
+	}
+}